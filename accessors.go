@@ -0,0 +1,290 @@
+package main
+
+// This file defines accessor functions (-var-accessors), which let
+// other clusters use an unexported package-level variable without
+// exporting the variable itself.
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"os"
+	"sort"
+
+	"github.com/arl/sockdrawer/analysis"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// An accessor describes the getter and setter functions
+// that replace the cross-cluster uses of a variable.
+type accessor struct {
+	v        *types.Var
+	get, set string              // names of the getter and setter
+	setters  map[*ast.Ident]bool // uses to be replaced by a call of the setter
+}
+
+// planAccessors returns the accessors for the unexported
+// package-level variables referenced from other clusters, reporting
+// each variable so handled, and each that must be exported instead
+// because one of its uses can't be rewritten as a call.
+func (o *organizer) planAccessors(rules []exportRule) map[types.Object]*accessor {
+	uses := make(map[*types.Var][]*ast.Ident) // cross-cluster uses
+	addressed := make(map[*ast.Ident]bool)
+	setters := make(map[*ast.Ident]bool)
+	for _, n := range o.Nodes {
+		if n.IsTest() {
+			continue // tests are not refactored
+		}
+		var crosses bool
+		for id, obj := range n.Uses {
+			if v, ok := obj.(*types.Var); ok && analysis.IsPackageLevel(v) && !v.Exported() &&
+				o.NodesByObj[v].Cluster != n.Cluster {
+				uses[v] = append(uses[v], id)
+				crosses = true
+			}
+		}
+		if crosses {
+			o.classifyVarUses(n, addressed, setters)
+		}
+	}
+
+	vars := make([]*types.Var, 0, len(uses))
+	for v := range uses {
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Pos() < vars[j].Pos() })
+
+	accessors := make(map[types.Object]*accessor)
+outer:
+	for _, v := range vars {
+		ids := uses[v]
+		sort.Slice(ids, func(i, j int) bool { return ids[i].Pos() < ids[j].Pos() })
+		for _, id := range ids {
+			if addressed[id] && !setters[id] {
+				fmt.Fprintf(os.Stderr, "%s: warning: exporting %s: can't replace this use by an accessor\n",
+					o.Fset.Position(id.Pos()), v.Name())
+				continue outer
+			}
+		}
+		if _, ok := o.varTypeText(v); !ok {
+			fmt.Fprintf(os.Stderr, "%s: warning: exporting %s: can't express its type %s in an accessor\n",
+				o.Fset.Position(v.Pos()), v.Name(), v.Type())
+			continue
+		}
+		acc := &accessor{
+			v:       v,
+			get:     exportedName(applyExportRules(rules, v.Name())),
+			setters: make(map[*ast.Ident]bool),
+		}
+		acc.set = "Set" + acc.get
+		for _, name := range []string{acc.get, acc.set} {
+			if o.Info.Pkg.Scope().Lookup(name) != nil {
+				fmt.Fprintf(os.Stderr, "%s: warning: exporting %s: accessor %s would conflict\n",
+					o.Fset.Position(v.Pos()), v.Name(), name)
+				continue outer
+			}
+		}
+		for _, id := range ids {
+			if setters[id] {
+				acc.setters[id] = true
+			}
+		}
+		if len(acc.setters) > 0 {
+			fmt.Fprintf(os.Stderr, "%s: note: accessing %s from other clusters through %s and %s\n",
+				o.Fset.Position(v.Pos()), v.Name(), acc.get, acc.set)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: note: accessing %s from other clusters through %s\n",
+				o.Fset.Position(v.Pos()), v.Name(), acc.get)
+		}
+		accessors[v] = acc
+	}
+	return accessors
+}
+
+// classifyVarUses records the identifiers within n that are
+// addressed: modified in place, having their address taken
+// (explicitly, or implicitly by a pointer method call), or assigned.
+// Of these, the identifiers that form the entire left side of a
+// simple assignment "x = e", and may thus be replaced by a call of
+// a setter, are also recorded in setters.
+func (o *organizer) classifyVarUses(n *analysis.Node, addressed, setters map[*ast.Ident]bool) {
+	// root returns the variable identifier that x denotes
+	// part of, or nil if x denotes indirect storage.
+	var root func(x ast.Expr) *ast.Ident
+	root = func(x ast.Expr) *ast.Ident {
+		switch x := x.(type) {
+		case *ast.Ident:
+			return x
+		case *ast.ParenExpr:
+			return root(x.X)
+		case *ast.SelectorExpr:
+			if _, ok := o.Info.Selections[x]; !ok {
+				return nil // qualified identifier
+			}
+			if _, ok := o.Info.TypeOf(x.X).Underlying().(*types.Pointer); ok {
+				return nil
+			}
+			return root(x.X)
+		case *ast.IndexExpr:
+			if _, ok := o.Info.TypeOf(x.X).Underlying().(*types.Array); ok {
+				return root(x.X)
+			}
+		}
+		return nil
+	}
+	mark := func(x ast.Expr) {
+		if id := root(x); id != nil {
+			addressed[id] = true
+		}
+	}
+
+	ast.Inspect(n.Syntax, func(syntax ast.Node) bool {
+		switch syntax := syntax.(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range syntax.Lhs {
+				mark(lhs)
+			}
+			if syntax.Tok == token.ASSIGN && len(syntax.Lhs) == 1 && len(syntax.Rhs) == 1 {
+				if id, ok := syntax.Lhs[0].(*ast.Ident); ok {
+					setters[id] = true
+				}
+			}
+		case *ast.IncDecStmt:
+			mark(syntax.X)
+		case *ast.RangeStmt:
+			if syntax.Tok == token.ASSIGN {
+				if syntax.Key != nil {
+					mark(syntax.Key)
+				}
+				if syntax.Value != nil {
+					mark(syntax.Value)
+				}
+			}
+		case *ast.UnaryExpr:
+			if syntax.Op == token.AND {
+				mark(syntax.X)
+			}
+		case *ast.SelectorExpr:
+			if sel := o.Info.Selections[syntax]; sel != nil && sel.Kind() == types.MethodVal {
+				if sig := sel.Obj().Type().(*types.Signature); sig.Recv() != nil {
+					_, ptrRecv := sig.Recv().Type().(*types.Pointer)
+					_, ptrX := o.Info.TypeOf(syntax.X).Underlying().(*types.Pointer)
+					if ptrRecv && !ptrX {
+						mark(syntax.X)
+					}
+				}
+			}
+		}
+		return true
+	})
+}
+
+// varTypeText returns the source text of the type of v, for use in
+// the declarations of its accessors: the type expression of its
+// declaration, if it has one, or otherwise the type itself if it
+// involves no named types.
+func (o *organizer) varTypeText(v *types.Var) (string, bool) {
+	if spec, ok := o.valueSpec(v); ok && spec.Type != nil {
+		var buf bytes.Buffer
+		if err := format.Node(&buf, o.Fset, spec.Type); err != nil {
+			return "", false
+		}
+		return buf.String(), true
+	}
+	var named bool
+	typ := types.TypeString(v.Type(), func(*types.Package) string {
+		named = true
+		return ""
+	})
+	return typ, !named
+}
+
+// valueSpec returns the ValueSpec declaring the package-level var v.
+func (o *organizer) valueSpec(v *types.Var) (*ast.ValueSpec, bool) {
+	var spec *ast.ValueSpec
+	ast.Inspect(o.NodesByObj[v].Syntax, func(syntax ast.Node) bool {
+		if s, ok := syntax.(*ast.ValueSpec); ok {
+			for _, id := range s.Names {
+				if o.Info.Defs[id] == v {
+					spec = s
+				}
+			}
+		}
+		return spec == nil
+	})
+	return spec, spec != nil
+}
+
+// rewriteAccessors replaces each use within n of a variable of
+// another cluster with accessors by a call of its getter, "p.X()",
+// and each assignment to one, "x = e", by a call of its setter,
+// "p.SetX(e)".
+func (o *organizer) rewriteAccessors(n *analysis.Node) {
+	// call returns a call of the named accessor of the variable
+	// used by id, qualified by the name of its cluster.
+	call := func(id *ast.Ident, acc *accessor, name string, args []ast.Expr, rparen token.Pos) *ast.CallExpr {
+		return &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   &ast.Ident{NamePos: id.Pos(), Name: o.clusterNames[o.NodesByObj[acc.v].Cluster]},
+				Sel: &ast.Ident{NamePos: id.Pos(), Name: name},
+			},
+			Lparen: id.End(),
+			Args:   args,
+			Rparen: rparen,
+		}
+	}
+	astutil.Apply(n.Syntax, func(c *astutil.Cursor) bool {
+		switch syntax := c.Node().(type) {
+		case *ast.AssignStmt:
+			if len(syntax.Lhs) != 1 {
+				break
+			}
+			id, ok := syntax.Lhs[0].(*ast.Ident)
+			if !ok {
+				break
+			}
+			if acc := o.accessors[n.Uses[id]]; acc != nil && acc.setters[id] &&
+				o.NodesByObj[acc.v].Cluster != n.Cluster {
+				c.Replace(&ast.ExprStmt{X: call(id, acc, acc.set, syntax.Rhs, syntax.End())})
+			}
+
+		case *ast.Ident:
+			if acc := o.accessors[n.Uses[syntax]]; acc != nil && !acc.setters[syntax] &&
+				o.NodesByObj[acc.v].Cluster != n.Cluster {
+				c.Replace(call(syntax, acc, acc.get, nil, syntax.End()))
+			}
+		}
+		return true
+	}, nil)
+}
+
+// writeAccessors appends the declarations of the accessor functions
+// to the output file of the cluster declaring each variable.
+// The setter is declared only if some other cluster assigns the
+// variable.  It must be called after renaming, so that the type of
+// each variable is expressed as in its new package.
+func (o *organizer) writeAccessors() {
+	accs := make([]*accessor, 0, len(o.accessors))
+	for _, acc := range o.accessors {
+		accs = append(accs, acc)
+	}
+	sort.Slice(accs, func(i, j int) bool { return accs[i].v.Pos() < accs[j].v.Pos() })
+	for _, acc := range accs {
+		n := o.NodesByObj[acc.v]
+		out := o.file(n.Cluster, n.Filename())
+		if out.groupDecl != nil {
+			out.body.WriteString(")\n")
+			out.groupDecl = nil
+		}
+		typ, _ := o.varTypeText(acc.v)
+		fmt.Fprintf(&out.body, "\n// %s returns the value of %s.\nfunc %s() %s { return %s }\n",
+			acc.get, acc.v.Name(), acc.get, typ, acc.v.Name())
+		if len(acc.setters) > 0 {
+			fmt.Fprintf(&out.body, "\n// %s sets the value of %s.\nfunc %s(v %s) { %s = v }\n",
+				acc.set, acc.v.Name(), acc.set, typ, acc.v.Name())
+		}
+	}
+}
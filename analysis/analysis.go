@@ -0,0 +1,175 @@
+// Package analysis builds the node graph of a Go package, with a node
+// for each top-level declaration and an edge for each reference among
+// them, and partitions it into clusters, each a candidate subpackage,
+// as directed by a clusters file.  See the sockdrawer command for the
+// format of the clusters file.
+package analysis
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"io"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+)
+
+// Options configures the analysis of a package.
+type Options struct {
+	ResiduePath  string    // import path of the residue cluster (default "residue")
+	PathTemplate string    // template of the import paths of generated clusters, as for GeneratedPath
+	Bands        int       // absent a clusters file, partition into about this many balanced clusters
+	HotPath      string    // file naming performance-critical nodes to keep together, if any
+	Tests        bool      // include the package's tests in the analysis
+	PinInit      bool      // keep init functions in the residue
+	SplitMethods bool      // let the clusters file assign methods apart from their receiver types
+	Colocate     bool      // assign unassigned members of declaration groups with the other members
+	FuseExports  bool      // SCGraph(true) doesn't fuse exported scnodes into unexported ones, or vice versa
+	Verbosity    int       // print progress (1), and the cluster of each node (2), to Log
+	Log          io.Writer // destination of warnings and progress; nil discards them
+}
+
+// A Graph is the node graph of a package: a node for each top-level
+// declaration, and an edge from each node to those it refers to.
+type Graph struct {
+	Fset       *token.FileSet
+	Info       *loader.PackageInfo
+	XTest      *loader.PackageInfo // external test package, if any (Options.Tests)
+	Nodes      []*Node             // nodes for top-level decls/specs, in lexical order
+	NodesByObj map[types.Object]*Node
+	Forbids    []Forbid       // constraints from the clusters file
+	Hot        map[*Node]bool // performance-critical nodes (Options.HotPath)
+	HotEdges   map[Edge]bool  // synthetic reverse edges among hot nodes
+	Options    Options
+
+	// With MergeConfigs, Configs holds the names of the build
+	// configurations, primary first, and Absent maps the name of each
+	// node absent from the primary configuration to those declaring it.
+	Configs []string
+	Absent  map[string][]string
+
+	// recvEdges holds the synthetic edges from receiver types to
+	// their methods, and reboundEdges those that the clusters file
+	// added in their stead; Partition restores the former.
+	recvEdges    []Edge
+	reboundEdges []Edge
+}
+
+// NewGraph builds the node graph of the package info and,
+// if non-nil, of its external test package xtest.
+func NewGraph(fset *token.FileSet, info, xtest *loader.PackageInfo, opts Options) *Graph {
+	g := newGraph(fset, info, xtest, opts)
+	g.buildNodeGraph()
+	return g
+}
+
+// newGraph returns a graph of the package info, with no nodes.
+func newGraph(fset *token.FileSet, info, xtest *loader.PackageInfo, opts Options) *Graph {
+	if opts.ResiduePath == "" {
+		opts.ResiduePath = "residue"
+	}
+	if opts.PathTemplate == "" {
+		opts.PathTemplate = "{{.PkgPath}}/{{.ClusterName}}"
+	}
+	return &Graph{
+		Fset:       fset,
+		Info:       info,
+		XTest:      xtest,
+		NodesByObj: make(map[types.Object]*Node),
+		Options:    opts,
+	}
+}
+
+// InitialPackages returns the package to split, among the initial
+// packages of prog, and, if tests is set, its external test package.
+func InitialPackages(prog *loader.Program, tests bool) (info, xtest *loader.PackageInfo) {
+	// TODO(adonovan): fix: generalize to multiple packages.
+	for _, p := range prog.InitialPackages() {
+		if tests && strings.HasSuffix(p.Pkg.Path(), "_test") {
+			xtest = p
+		} else if info == nil {
+			info = p
+		}
+	}
+	if info == nil {
+		info = xtest // no package under test
+		xtest = nil
+	}
+	return info, xtest
+}
+
+// Partition loads the clusters file, if any, and returns the implied
+// partition in topological order, residue last.
+// Any previous partition is discarded.
+func (g *Graph) Partition(clusterFile string) ([]*Cluster, error) {
+	for _, n := range g.Nodes {
+		n.Cluster = nil
+		n.Colocated = false
+	}
+	// Undo the method rebindings and detachments of the previous
+	// clusters file.
+	for _, e := range g.reboundEdges {
+		delete(e.From.Succs, e.To)
+		delete(e.To.Preds, e.From)
+	}
+	g.reboundEdges = nil
+	for _, e := range g.recvEdges {
+		AddEdge(e.From, e.To)
+	}
+	var clusters []*Cluster // topological order
+	var err error
+	if g.Options.Bands > 0 && clusterFile == "" {
+		if clusters, err = g.bandPartition(g.Options.Bands); err != nil {
+			return nil, err
+		}
+	} else if clusterFile != "" {
+		if clusters, g.Forbids, err = g.loadClusterFile(clusterFile); err != nil {
+			return nil, err
+		}
+	}
+	if g.Options.Colocate {
+		g.colocate(clusters)
+	}
+	return g.addResidualCluster(clusters), nil
+}
+
+// A Result is the outcome of Analyze.
+type Result struct {
+	*Graph
+	Clusters []*Cluster // topological order, residue last
+}
+
+// Analyze builds the node graph of the package to split, among the
+// initial packages of prog, and partitions it by the clusters file,
+// if any.
+func Analyze(prog *loader.Program, clusterFile string, opts Options) (*Result, error) {
+	info, xtest := InitialPackages(prog, opts.Tests)
+	g := NewGraph(prog.Fset, info, xtest, opts)
+	if opts.HotPath != "" {
+		if err := g.LoadHotNodes(opts.HotPath); err != nil {
+			return nil, err
+		}
+	}
+	clusters, err := g.Partition(clusterFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{g, clusters}, nil
+}
+
+// logf prints a message to Options.Log if the verbosity
+// level (Options.Verbosity) is at least the specified level.
+func (g *Graph) logf(level int, format string, args ...interface{}) {
+	if g.Options.Verbosity >= level {
+		g.printf(format, args...)
+	}
+}
+
+// printf prints a message, such as a warning about the clusters
+// file, to Options.Log, if any.
+func (g *Graph) printf(format string, args ...interface{}) {
+	if g.Options.Log != nil {
+		fmt.Fprintf(g.Options.Log, format, args...)
+	}
+}
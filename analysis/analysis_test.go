@@ -0,0 +1,56 @@
+package analysis_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arl/sockdrawer/analysis"
+	"github.com/arl/sockdrawer/internal/analysistest"
+)
+
+// Analyze partitions the package by the clusters file, residue last,
+// with the residue under the import path of the options.
+func TestAnalyze(t *testing.T) {
+	prog := analysistest.LoadProgram(t, analysistest.WriteFiles(t, map[string]string{"p.go": `package p
+
+func a() { b() }
+
+func b() {}
+
+func c() { a() }
+`}))
+	for _, test := range []struct {
+		clusters string
+		opts     analysis.Options
+		want     string
+	}{
+		{"= p/low\nb\n\n= p/mid\na\n", analysis.Options{ResiduePath: "p"}, "p/low: b; p/mid: a; p: c"},
+		{"= p/low\na\n", analysis.Options{}, "p/low: a b; residue: c"},
+		{"", analysis.Options{}, "residue: a b c"},
+		{"", analysis.Options{Bands: 3}, "p/part0: b; p/part1: a; p/part2: c"},
+	} {
+		var filename string
+		if test.clusters != "" {
+			filename = analysistest.WriteClusters(t, test.clusters)
+		}
+		res, err := analysis.Analyze(prog, filename, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range res.Clusters {
+			var names []string
+			for _, n := range analysis.SortedNodes(c.Nodes) {
+				names = append(names, n.Name)
+			}
+			got = append(got, c.ImportPath+": "+strings.Join(names, " "))
+		}
+		if got := strings.Join(got, "; "); got != test.want {
+			t.Errorf("Analyze(%q, %+v) = %q, want %q", test.clusters, test.opts, got, test.want)
+		}
+		if last := res.Clusters[len(res.Clusters)-1]; last.IsResidue() != (test.opts.Bands == 0) {
+			t.Errorf("Analyze(%q, %+v): IsResidue of last cluster %s = %t",
+				test.clusters, test.opts, last.ImportPath, last.IsResidue())
+		}
+	}
+}
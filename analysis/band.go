@@ -0,0 +1,80 @@
+package analysis
+
+// This file defines the automatic partition of the package into
+// balanced bands of the scnode DAG (Options.Bands).
+
+import (
+	"fmt"
+	"sort"
+)
+
+// bandPartition partitions the package into approximately k clusters
+// of roughly equal node count, without a clusters file.  Each scnode
+// is assigned a height, the length of the longest path from it to a
+// sink, and the scnodes are cut into bands in order of height.  Since
+// every edge points to an scnode of strictly lesser height, the
+// resulting cluster graph is acyclic.  Clusters are returned in
+// topological order, lowest first.
+func (g *Graph) bandPartition(k int) ([]*Cluster, error) {
+	scnodes := g.SCGraph(false)
+
+	height := make(map[*SCNode]int)
+	var visit func(s *SCNode) int
+	visit = func(s *SCNode) int {
+		h, ok := height[s]
+		if !ok {
+			for succ := range s.Succs {
+				if hs := visit(succ) + 1; hs > h {
+					h = hs
+				}
+			}
+			height[s] = h
+		}
+		return h
+	}
+	order := make([]*SCNode, 0, len(scnodes))
+	for s := range scnodes {
+		visit(s)
+		order = append(order, s)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if hi, hj := height[order[i]], height[order[j]]; hi != hj {
+			return hi < hj
+		}
+		return order[i].ID < order[j].ID
+	})
+
+	var clusters []*Cluster
+	var c *Cluster
+	var assigned int
+	for _, s := range order {
+		// Start a new band once the current one has its share.
+		if c == nil || len(clusters) < k && assigned >= len(clusters)*len(g.Nodes)/k {
+			if c != nil {
+				c.finish(g)
+			}
+			importPath, err := g.GeneratedPath(fmt.Sprintf("part%d", len(clusters)))
+			if err != nil {
+				return nil, err
+			}
+			c = &Cluster{
+				ID:         len(clusters),
+				ImportPath: importPath,
+				Nodes:      make(map[*Node]bool),
+			}
+			clusters = append(clusters, c)
+		}
+		for n := range s.Nodes {
+			if !n.IsPinned() {
+				n.Cluster = c
+				c.Nodes[n] = true
+			}
+		}
+		s.Cluster = c
+		assigned += len(s.Nodes)
+	}
+	if c != nil {
+		c.finish(g)
+	}
+	return clusters, nil
+}
@@ -0,0 +1,233 @@
+package analysis
+
+// This file defines the cache of the node graph, which spares a run
+// whose sources are unchanged the loading and type-checking.
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"golang.org/x/tools/go/loader"
+)
+
+// cacheVersion identifies the format of the cache file; a cache of
+// another version is stale.
+const cacheVersion = 1
+
+// A cache is the JSON form of a node graph.
+type cache struct {
+	Version int         `json:"version"`
+	Key     string      `json:"key"` // see CacheKey
+	PkgPath string      `json:"pkgPath"`
+	PkgName string      `json:"pkgName"`
+	Files   []cacheFile `json:"files"` // files declaring nodes, in order of base
+	Nodes   []cacheNode `json:"nodes"` // in order of ID
+}
+
+// A cacheFile records a token.File, so that the positions of the
+// cached graph denote the same places as those of the original.
+type cacheFile struct {
+	Name  string `json:"name"`
+	Base  int    `json:"base"`
+	Size  int    `json:"size"`
+	Lines []int  `json:"lines"` // offset of the start of each line
+}
+
+// A cacheNode records a Node.
+type cacheNode struct {
+	Name       string      `json:"name"`
+	LegacyName string      `json:"legacyName,omitempty"`
+	Pos        token.Pos   `json:"pos"`
+	End        token.Pos   `json:"end"`
+	Kind       string      `json:"kind"`
+	FuncName   string      `json:"funcName,omitempty"`
+	Recv       string      `json:"recv,omitempty"`
+	Names      []string    `json:"names,omitempty"`
+	Grouped    bool        `json:"grouped,omitempty"`
+	Exported   bool        `json:"exported,omitempty"`
+	Iface      bool        `json:"iface,omitempty"`
+	XTest      bool        `json:"xtest,omitempty"`
+	RecvNode   int         `json:"recvNode"` // ID, or -1 if none
+	Succs      []cacheEdge `json:"succs,omitempty"`
+}
+
+// A cacheEdge records an edge to the node of ID To, and the
+// positions of the references that form it.
+type cacheEdge struct {
+	To   int         `json:"to"`
+	Refs []token.Pos `json:"refs,omitempty"`
+}
+
+// CacheKey returns the key under which the node graph of the package
+// formed by the source files is cached: a hash of their names,
+// modification times and contents.  Any change to a file, even of its
+// modification time alone, changes the key.
+func CacheKey(filenames []string) (string, error) {
+	filenames = append([]string(nil), filenames...)
+	sort.Strings(filenames)
+	h := sha256.New()
+	fmt.Fprintf(h, "sockdrawer cache %d\n", cacheVersion)
+	for _, filename := range filenames {
+		f, err := os.Open(filename)
+		if err != nil {
+			return "", err
+		}
+		fi, err := f.Stat()
+		if err == nil {
+			fmt.Fprintf(h, "%s %d %d\n", filename, fi.ModTime().UnixNano(), fi.Size())
+			_, err = io.Copy(h, f)
+		}
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// WriteCache writes the node graph to the specified file, under the
+// key.  It must precede any change to the edges of the graph, such as
+// those of MergeConfigs, LoadHotNodes and Partition.
+func (g *Graph) WriteCache(filename, key string) error {
+	c := cache{
+		Version: cacheVersion,
+		Key:     key,
+		PkgPath: g.Info.Pkg.Path(),
+		PkgName: g.Info.Pkg.Name(),
+	}
+	files := make(map[*token.File]bool)
+	for _, n := range g.Nodes {
+		if f := g.Fset.File(n.pos); f != nil && !files[f] {
+			files[f] = true
+			cf := cacheFile{Name: f.Name(), Base: f.Base(), Size: f.Size()}
+			for line := 1; line <= f.LineCount(); line++ {
+				cf.Lines = append(cf.Lines, f.Offset(f.LineStart(line)))
+			}
+			c.Files = append(c.Files, cf)
+		}
+
+		cn := cacheNode{
+			Name:       n.Name,
+			LegacyName: n.legacyName,
+			Pos:        n.pos,
+			End:        n.end,
+			Kind:       n.kind,
+			FuncName:   n.funcName,
+			Recv:       n.recv,
+			Names:      n.names,
+			Grouped:    n.grouped,
+			Exported:   n.exported,
+			Iface:      n.iface,
+			XTest:      n.XTest,
+			RecvNode:   -1,
+		}
+		if n.recvNode != nil {
+			cn.RecvNode = n.recvNode.ID
+		}
+		for _, succ := range SortedNodes(n.Succs) {
+			cn.Succs = append(cn.Succs, cacheEdge{To: succ.ID, Refs: n.refs[succ]})
+		}
+		c.Nodes = append(c.Nodes, cn)
+	}
+	sort.Slice(c.Files, func(i, j int) bool { return c.Files[i].Base < c.Files[j].Base })
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0666)
+}
+
+// ReadCache reads the node graph written by WriteCache to the
+// specified file, if its key is the specified one, and returns it,
+// with the options, as NewGraph would.  It returns nil if the file
+// does not exist, or holds the graph of another key.
+//
+// The graph lacks syntax and type information: its Info holds only
+// the package, its XTest is nil, and the Syntax, Uses, Objects and
+// Recv of each node are empty.
+func ReadCache(filename, key string, opts Options) (*Graph, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var c cache
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if c.Version != cacheVersion || c.Key != key {
+		return nil, nil // stale
+	}
+
+	fset := token.NewFileSet()
+	for _, cf := range c.Files {
+		if cf.Base < fset.Base() {
+			return nil, fmt.Errorf("%s: overlapping files", filename)
+		}
+		if !fset.AddFile(cf.Name, cf.Base, cf.Size).SetLines(cf.Lines) {
+			return nil, fmt.Errorf("%s: invalid lines of %s", filename, cf.Name)
+		}
+	}
+	info := &loader.PackageInfo{Pkg: types.NewPackage(c.PkgPath, c.PkgName)}
+	g := newGraph(fset, info, nil, opts)
+	for id, cn := range c.Nodes {
+		g.Nodes = append(g.Nodes, &Node{
+			Graph:      g,
+			ID:         id,
+			Name:       cn.Name,
+			legacyName: cn.LegacyName,
+			Uses:       make(map[*ast.Ident]types.Object),
+			Succs:      make(map[*Node]bool),
+			Preds:      make(map[*Node]bool),
+			XTest:      cn.XTest,
+			pos:        cn.Pos,
+			end:        cn.End,
+			kind:       cn.Kind,
+			funcName:   cn.FuncName,
+			names:      cn.Names,
+			grouped:    cn.Grouped,
+			recv:       cn.Recv,
+			exported:   cn.Exported,
+			iface:      cn.Iface,
+			refs:       make(map[*Node][]token.Pos),
+		})
+	}
+	node := func(id int) (*Node, error) {
+		if id < 0 || id >= len(g.Nodes) {
+			return nil, fmt.Errorf("%s: invalid node ID %d", filename, id)
+		}
+		return g.Nodes[id], nil
+	}
+	for id, cn := range c.Nodes {
+		n := g.Nodes[id]
+		for _, e := range cn.Succs {
+			succ, err := node(e.To)
+			if err != nil {
+				return nil, err
+			}
+			AddEdge(n, succ)
+			if e.Refs != nil {
+				n.refs[succ] = e.Refs
+			}
+		}
+		if cn.RecvNode >= 0 {
+			t, err := node(cn.RecvNode)
+			if err != nil {
+				return nil, err
+			}
+			n.recvNode = t
+			g.recvEdges = append(g.recvEdges, Edge{From: t, To: n})
+		}
+	}
+	return g, nil
+}
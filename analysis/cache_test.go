@@ -0,0 +1,131 @@
+package analysis_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arl/sockdrawer/analysis"
+	"github.com/arl/sockdrawer/internal/analysistest"
+)
+
+// A graph read from the cache has the nodes, IDs, edges, positions and
+// kinds of the original, and the same partition by a clusters file.
+func TestCache(t *testing.T) {
+	g := analysistest.LoadSource(t, analysis.Options{}, map[string]string{"p.go": `package p
+
+const (
+	a, b = 1, 2
+	c    = a
+)
+
+type I interface{ m() }
+
+type T int
+
+func (T) m() { _ = c }
+
+type U int
+
+func init() { var _ I = T(0) }
+`})
+	filename := filepath.Join(t.TempDir(), "p.cache")
+	if err := g.WriteCache(filename, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if stale, err := analysis.ReadCache(filename, "k2", analysis.Options{}); err != nil || stale != nil {
+		t.Fatalf("ReadCache with another key = %v, %v, want nil", stale, err)
+	}
+	cached, err := analysis.ReadCache(filename, "k1", analysis.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached == nil {
+		t.Fatal("ReadCache with the same key = nil")
+	}
+
+	// describe returns the attributes of each node of g.
+	describe := func(g *analysis.Graph) string {
+		var buf strings.Builder
+		for _, n := range g.Nodes {
+			fmt.Fprintf(&buf, "%d %s %s %s-%s exported=%d pinned=%t recv=%q succs=[%s]",
+				n.ID, n, n.Kind(), g.Fset.Position(n.Pos()), g.Fset.Position(n.End()),
+				n.Exportedness(), n.IsPinned(), n.RecvType(), analysistest.SuccNames(n))
+			if t := n.RecvNode(); t != nil {
+				fmt.Fprintf(&buf, " recvNode=%d", t.ID)
+			}
+			for _, succ := range analysis.SortedNodes(n.Succs) {
+				for _, pos := range n.Refs(succ) {
+					fmt.Fprintf(&buf, " ref=%s", g.Fset.Position(pos))
+				}
+			}
+			buf.WriteString("\n")
+		}
+		return buf.String()
+	}
+	if got, want := describe(cached), describe(g); got != want {
+		t.Errorf("cached graph:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := cached.Info.Pkg.Path(), g.Info.Pkg.Path(); got != want {
+		t.Errorf("cached package path = %s, want %s", got, want)
+	}
+
+	// The clusters file names a node by the second name it declares,
+	// and rebinds a method.
+	const clusters = "= p/low\nb\nU\n(T).m -> U\n"
+	partition := func(g *analysis.Graph) string {
+		var buf strings.Builder
+		for _, c := range analysistest.Partition(t, g, clusters) {
+			fmt.Fprintf(&buf, "%s:", c.ImportPath)
+			for _, n := range analysis.SortedNodes(c.Nodes) {
+				fmt.Fprintf(&buf, " %s", n.Name)
+			}
+			buf.WriteString("\n")
+		}
+		return buf.String()
+	}
+	if got, want := partition(cached), partition(g); got != want {
+		t.Errorf("partition of cached graph:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// The key changes with the content or modification time of a file.
+func TestCacheKey(t *testing.T) {
+	dir := analysistest.WriteFiles(t, map[string]string{"p.go": "package p\n"})
+	filename := filepath.Join(dir, "p.go")
+	key := func() string {
+		t.Helper()
+		key, err := analysis.CacheKey([]string{filename})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	key1 := key()
+	if key2 := key(); key2 != key1 {
+		t.Errorf("key of unchanged file changed from %s to %s", key1, key2)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	key2 := key()
+	if key2 == key1 {
+		t.Errorf("key unchanged by modification time")
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("package q\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if key3 := key(); key3 == key2 {
+		t.Errorf("key unchanged by content")
+	}
+}
@@ -0,0 +1,659 @@
+package analysis
+
+// This file defines the cluster graph.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// A Cluster is a set of nodes destined for the same package: one
+// declared by a stanza of the clusters file, or the residue.
+type Cluster struct {
+	ID         int
+	ImportPath string // declared name, e.g. "runtime/internal/core"
+	Nodes      map[*Node]bool
+	Wanted     []*Node     // nodes named in the stanza but claimed by earlier clusters
+	Stanza     ClusterLine // the "= importpath" line, if declared by a clusters file
+	residue    bool
+}
+
+// IsResidue reports whether c is the residue, the cluster of all
+// nodes not assigned elsewhere, which retains the package's identity.
+func (c *Cluster) IsResidue() bool {
+	return c.residue
+}
+
+func (c *Cluster) finish(g *Graph) {
+	// mark applies n's cluster to all nodes reachable from it that
+	// don't have a cluster assignment yet.
+	var mark func(n *Node)
+	mark = func(n *Node) {
+		for s := range n.Succs {
+			if s.Cluster == nil && !s.IsPinned() {
+				s.Cluster = n.Cluster
+				n.Cluster.Nodes[s] = true
+				g.logf(2, "\t%-50s (indirect)\n", s)
+				mark(s)
+			}
+		}
+	}
+
+	var first, prev *Node
+	for n := range c.Nodes {
+		if first == nil {
+			first = n
+		}
+		if prev != nil {
+			mark(n)
+		}
+		prev = n
+	}
+	if prev != nil {
+		mark(first)
+	}
+}
+
+// A Forbid records a "forbid: A -> B" constraint of the clusters
+// file: no node of cluster A may depend on a node of cluster B.
+type Forbid struct {
+	Line     ClusterLine
+	From, To string // import paths
+}
+
+func (g *Graph) loadClusterFile(filename string) ([]*Cluster, []Forbid, error) {
+	nodes := g.Nodes
+	clusterNames := map[string]bool{g.Options.ResiduePath: true}
+
+	byName := make(map[string]*Node)
+	for _, n := range nodes {
+		byName[n.Name] = n
+	}
+	// A node declaring several names, e.g. "var a, b = f()", is named
+	// after the first, but may also be mentioned by the others.
+	for _, n := range nodes {
+		if n.recv != "" || len(n.names) < 2 {
+			continue
+		}
+		prefix := strings.TrimSuffix(n.Name, n.names[0]) // e.g. "p_test."
+		for _, name := range n.names[1:] {
+			name = prefix + name
+			if byName[name] == nil {
+				byName[name] = n
+			}
+		}
+	}
+	legacy := make(map[string]*Node) // former names of anonymous nodes
+	for _, n := range nodes {
+		if n.legacyName != "" && byName[n.legacyName] == nil {
+			legacy[n.legacyName] = n
+		}
+	}
+
+	lines, err := ReadClusterLines(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Apply method rebindings and detachments first, since they
+	// change the edges along which the stanzas below propagate
+	// cluster assignments.
+	var rebound bool
+	var forbids []Forbid
+	var detached []*Node               // methods named in stanzas (Options.SplitMethods)
+	stanzaOf := make(map[*Node]string) // the stanza first naming each node
+	var stanza string
+	for _, l := range lines {
+		if strings.HasPrefix(l.Text, "= ") {
+			stanza = strings.TrimSpace(l.Text[2:])
+		} else if n := byName[l.Text]; n != nil && stanzaOf[n] == "" {
+			stanzaOf[n] = stanza
+		}
+		if m := lookupMethod(byName, l.Text); m != nil {
+			if g.Options.SplitMethods && detachMethod(m) {
+				detached = append(detached, m)
+			}
+		} else if strings.HasPrefix(l.Text, "forbid:") {
+			f := Forbid{Line: l}
+			arrow := strings.Index(l.Text, "->")
+			if arrow >= 0 {
+				f.From = strings.TrimSpace(l.Text[len("forbid:"):arrow])
+				f.To = strings.TrimSpace(l.Text[arrow+len("->"):])
+			}
+			if f.From == "" || f.To == "" {
+				g.printf(
+					"%s:%d: warning: malformed forbid directive; want 'forbid: A -> B'\n",
+					l.Filename, l.Linenum)
+				continue
+			}
+			forbids = append(forbids, f)
+		} else if i := strings.Index(l.Text, "->"); i >= 0 {
+			from := strings.TrimSpace(l.Text[:i])
+			to := strings.TrimSpace(l.Text[i+len("->"):])
+			if g.rebindMethod(l, lookupMethod(byName, from), from, byName[to], to) {
+				rebound = true
+			}
+		}
+	}
+
+	var c *Cluster
+	var ignoring bool // in an ignored stanza
+	var clusters []*Cluster
+	for _, l := range lines {
+		line := l.Text
+		if strings.HasPrefix(line, "= ") {
+			if c != nil {
+				c.finish(g)
+			}
+
+			c = &Cluster{
+				ID:         len(clusters),
+				ImportPath: strings.TrimSpace(line[2:]),
+				Nodes:      make(map[*Node]bool),
+				Stanza:     l,
+			}
+			if c.ImportPath == g.Options.ResiduePath {
+				g.printf(
+					"%s:%d: warning: cluster name %s is reserved; ignoring\n",
+					l.Filename, l.Linenum, c.ImportPath)
+				c, ignoring = nil, true // ignore its nodes too
+				continue
+			}
+			if why := CheckImportPath(c.ImportPath); why != "" {
+				g.printf(
+					"%s:%d: warning: invalid cluster import path %q: %s; ignoring\n",
+					l.Filename, l.Linenum, c.ImportPath, why)
+				c, ignoring = nil, true // ignore its nodes too
+				continue
+			}
+			if clusterNames[c.ImportPath] {
+				g.printf(
+					"%s:%d: warning: duplicate cluster name: %s; ignoring\n",
+					l.Filename, l.Linenum, c.ImportPath)
+				c, ignoring = nil, true // ignore its nodes too
+				continue
+			}
+			ignoring = false
+			clusterNames[c.ImportPath] = true
+			clusters = append(clusters, c)
+			g.logf(1, "# cluster %s\n", c.ImportPath)
+			continue
+		}
+		if strings.Contains(line, "->") {
+			continue // method rebinding or forbid; see above
+		}
+		if c == nil {
+			if !ignoring {
+				g.printf(
+					"%s:%d: warning: node before '= cluster' marker; ignoring\n",
+					l.Filename, l.Linenum)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "kind:") {
+			g.assignKind(l, c, nodes, strings.TrimSpace(line[len("kind:"):]))
+			continue
+		}
+
+		n := byName[line]
+		if n == nil {
+			n = lookupMethod(byName, line)
+		}
+		if n == nil && isGlob(line) {
+			var matched bool
+			for _, n := range nodes {
+				if !globMatch(line, n) {
+					continue
+				}
+				matched = true
+				if n.IsPinned() {
+					continue // silently, unlike a named node
+				}
+				if n.Cluster != nil {
+					if n.Cluster != c {
+						g.printf(
+							"%s:%d: warning: node %q (matching %q) appears in clusters %q and %q; ignoring\n",
+							l.Filename, l.Linenum, n.Name, line, n.Cluster.ImportPath, c.ImportPath)
+						c.Wanted = append(c.Wanted, n)
+					}
+					continue
+				}
+				n.Cluster = c
+				g.logf(2, "\t%s (matching %s)\n", n, line)
+				c.Nodes[n] = true
+			}
+			if !matched {
+				g.printf(
+					"%s:%d: warning: pattern %q matches no nodes; ignoring\n",
+					l.Filename, l.Linenum, line)
+			}
+			continue
+		}
+		if n == nil && legacy[line] != nil {
+			n = legacy[line]
+			g.printf(
+				"%s:%d: warning: %q is the obsolete name of %s; please update\n",
+				l.Filename, l.Linenum, line, n.Name)
+		}
+		if n == nil {
+			g.printf(
+				"%s:%d: warning: can't find node %q; ignoring\n",
+				l.Filename, l.Linenum, line)
+		} else if t := n.RecvNode(); t != nil && !g.Options.SplitMethods && t.Cluster != c &&
+			(t.Cluster != nil || stanzaOf[t] != "" && stanzaOf[t] != c.ImportPath) {
+			where := stanzaOf[t]
+			if t.Cluster != nil {
+				where = t.Cluster.ImportPath
+			}
+			g.printf(
+				"%s:%d: warning: method %s must stay with its receiver type %s, in cluster %s, "+
+					"since a method is declared in its receiver's package; ignoring "+
+					"(use -split-methods to assign it apart and convert it by hand)\n",
+				l.Filename, l.Linenum, line, t.Name, where)
+		} else if n.IsPinned() {
+			g.printf(
+				"%s:%d: warning: %s must stay in the residue; ignoring\n",
+				l.Filename, l.Linenum, line)
+		} else if n.Cluster != nil {
+			var alias string
+			if line != n.Name {
+				alias = fmt.Sprintf(" (declared together with %s)", n.Name)
+			}
+			g.printf(
+				"%s:%d: warning: node %q%s appears in clusters %q and %q; ignoring\n",
+				l.Filename, l.Linenum, line, alias, n.Cluster.ImportPath, c.ImportPath)
+			if n.Cluster != c {
+				c.Wanted = append(c.Wanted, n)
+			}
+		} else {
+			n.Cluster = c
+			g.logf(2, "\t%s\n", n)
+			c.Nodes[n] = true
+		}
+	}
+	if c != nil {
+		c.finish(g)
+	}
+
+	// A method assigned away from its receiver type can't simply
+	// move, since methods must be declared in their receiver's package.
+	for _, m := range detached {
+		if t := m.RecvNode(); m.Cluster != t.Cluster {
+			g.printf(
+				"%s: warning: method %s is assigned apart from its receiver type %s; "+
+					"it will need manual conversion, e.g. to a function, "+
+					"perhaps with a shim method forwarding to it\n",
+				g.Fset.Position(m.pos), m.Name, t.Name)
+		}
+	}
+
+	if rebound {
+		// Rebinding a method adds edges that the
+		// stanza order may not respect.
+		if cycle := ClusterCycle(clusters); cycle != nil {
+			return nil, nil, fmt.Errorf("method rebinding makes the cluster graph cyclic: %s",
+				strings.Join(cycle, " -> "))
+		}
+	}
+
+	return clusters, forbids, nil
+}
+
+// isGlob reports whether the clusters-file entry is a pattern.
+func isGlob(entry string) bool {
+	return strings.ContainsAny(entry, "*?[")
+}
+
+// globMatch reports whether the pattern matches the name of n, in
+// the syntax of path.Match.  The pattern may also match a method by
+// the plain form of its name, without parens or star: "T.*" matches
+// all methods of T, such as "(*T).f" and "(T).g".
+func globMatch(pattern string, n *Node) bool {
+	if ok, _ := path.Match(pattern, n.Name); ok {
+		return true
+	}
+	if n.recv != "" {
+		plain := strings.NewReplacer("(", "", "*", "", ")", "").Replace(n.Name)
+		if ok, _ := path.Match(pattern, plain); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// assignKind handles a "kind: K" directive of the clusters file,
+// which assigns to cluster c all unassigned nodes of kind K.
+func (g *Graph) assignKind(l ClusterLine, c *Cluster, nodes []*Node, kind string) {
+	switch kind {
+	case "const", "func", "interface", "type", "var":
+	default:
+		g.printf(
+			"%s:%d: warning: unknown kind %q; want const, func, interface, type or var\n",
+			l.Filename, l.Linenum, kind)
+		return
+	}
+	var assigned, overlap int
+	for _, n := range nodes {
+		if !n.hasKind(kind) || n.IsPinned() || n.Cluster == c {
+			continue
+		}
+		if n.Cluster != nil {
+			overlap++
+			continue
+		}
+		n.Cluster = c
+		c.Nodes[n] = true
+		assigned++
+	}
+	g.printf("%s:%d: kind %s: assigned %d nodes to %s\n",
+		l.Filename, l.Linenum, kind, assigned, c.ImportPath)
+	if overlap > 0 {
+		g.printf(
+			"%s:%d: warning: %d nodes of kind %s already belong to other clusters\n",
+			l.Filename, l.Linenum, overlap, kind)
+	}
+}
+
+// GeneratedPath returns the import path of a generated cluster
+// (one not declared in a clusters file) by expanding
+// Options.PathTemplate.
+func (g *Graph) GeneratedPath(clusterName string) (string, error) {
+	tmpl, err := template.New("path-template").Parse(g.Options.PathTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid path template: %v", err)
+	}
+	var buf bytes.Buffer
+	data := struct{ PkgPath, ClusterName string }{g.Info.Pkg.Path(), clusterName}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid path template: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// A ClusterLine is a non-blank line of a clusters file,
+// stripped of comments and surrounding space.
+type ClusterLine struct {
+	Filename string // the clusters file, or a file it includes
+	Linenum  int
+	Text     string
+}
+
+// ReadClusterLines returns the lines of the named clusters file, with
+// each "@include file" directive replaced by the lines of that file,
+// recursively.  An included file's name is relative to the directory
+// of the including file.  The including files, outermost first, are
+// given by stack, for the detection of cycles.
+func ReadClusterLines(filename string, stack ...string) ([]ClusterLine, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stack = append(stack, filename)
+
+	in := bufio.NewScanner(f)
+	var linenum int
+	var lines []ClusterLine
+	for in.Scan() {
+		linenum++
+		line := strings.TrimSpace(in.Text())
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = strings.TrimSpace(line[:i]) // strip comments
+		}
+		if line == "" {
+			continue // skip blanks
+		}
+		if strings.HasPrefix(line, "@include ") {
+			included := strings.TrimSpace(line[len("@include "):])
+			if !filepath.IsAbs(included) {
+				included = filepath.Join(filepath.Dir(filename), included)
+			}
+			for _, outer := range stack {
+				if sameFile(outer, included) {
+					return nil, fmt.Errorf("%s:%d: include cycle: %s -> %s",
+						filename, linenum, strings.Join(stack, " -> "), included)
+				}
+			}
+			more, err := ReadClusterLines(included, stack...)
+			if err != nil {
+				if os.IsNotExist(err) {
+					err = fmt.Errorf("%s:%d: %v", filename, linenum, err)
+				}
+				return nil, err
+			}
+			lines = append(lines, more...)
+			continue
+		}
+		lines = append(lines, ClusterLine{filename, linenum, line})
+	}
+	if err := in.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// sameFile reports whether the named files are the same file.
+func sameFile(x, y string) bool {
+	if filepath.Clean(x) == filepath.Clean(y) {
+		return true
+	}
+	xi, err := os.Stat(x)
+	if err != nil {
+		return false
+	}
+	yi, err := os.Stat(y)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(xi, yi)
+}
+
+// lookupMethod returns the concrete method node named by entry, of
+// the form "(T).f" or "(*T).f", or nil if there is none.  Either form
+// names the method, whatever the form of its receiver.
+func lookupMethod(byName map[string]*Node, entry string) *Node {
+	alt := entry
+	switch {
+	case strings.HasPrefix(entry, "(*"):
+		alt = "(" + entry[len("(*"):]
+	case strings.HasPrefix(entry, "("):
+		alt = "(*" + entry[len("("):]
+	default:
+		return nil
+	}
+	for _, name := range []string{entry, alt} {
+		if n := byName[name]; n != nil && n.recv != "" {
+			return n
+		}
+	}
+	return nil
+}
+
+// detachMethod removes the synthetic edge from the receiver type of
+// method m to m, which keeps them in the same cluster, so that a
+// stanza naming m may assign it to a cluster other than its type's.
+// It reports whether the edge was present.
+func detachMethod(m *Node) bool {
+	t := m.RecvNode()
+	if t == nil || !t.Succs[m] {
+		return false
+	}
+	delete(t.Succs, m)
+	delete(m.Preds, t)
+	return true
+}
+
+// rebindMethod handles a clusters file entry of the form
+// "(*T).f -> U", which detaches the concrete method node m from its
+// receiver type T and binds it instead to the type node u, so that
+// it follows u into whichever cluster u is assigned to.
+// It reports whether the rebinding was applied.
+func (g *Graph) rebindMethod(l ClusterLine, m *Node, mname string, u *Node, uname string) bool {
+	if m == nil || m.recv == "" {
+		g.printf(
+			"%s:%d: warning: can't find method node %q; ignoring\n",
+			l.Filename, l.Linenum, mname)
+		return false
+	}
+	if u == nil || !u.isType() {
+		g.printf(
+			"%s:%d: warning: can't find type node %q; ignoring\n",
+			l.Filename, l.Linenum, uname)
+		return false
+	}
+
+	// Replace the synthetic edge from the receiver type
+	// to the method by a pair of edges to and from u.
+	// The method's real reference to its receiver type remains.
+	recvName := m.recv
+	if t := m.RecvNode(); t != nil {
+		delete(t.Succs, m)
+		delete(m.Preds, t)
+		recvName = t.Name
+	}
+	for _, e := range []Edge{{u, m}, {m, u}} {
+		if !e.From.Succs[e.To] {
+			AddEdge(e.From, e.To)
+			g.reboundEdges = append(g.reboundEdges, e)
+		}
+	}
+
+	g.printf(
+		"%s:%d: warning: method %s moves with %s; "+
+			"it will need manual conversion since it can't keep its receiver type %s\n",
+		l.Filename, l.Linenum, mname, uname, recvName)
+	return true
+}
+
+// ClusterCycle returns the import paths along a cycle in the
+// projection of the node graph onto clusters, or nil if none exists.
+func ClusterCycle(clusters []*Cluster) []string {
+	succs := make(map[*Cluster]map[*Cluster]bool)
+	for _, c := range clusters {
+		succs[c] = make(map[*Cluster]bool)
+		for n := range c.Nodes {
+			for s := range n.Succs {
+				if s.Cluster != nil && s.Cluster != c {
+					succs[c][s.Cluster] = true
+				}
+			}
+		}
+	}
+
+	const (
+		white = iota // unvisited
+		grey         // on the stack
+		black        // done
+	)
+	color := make(map[*Cluster]int)
+	var stack []*Cluster
+	var cycle []string
+	var visit func(c *Cluster) bool
+	visit = func(c *Cluster) bool {
+		color[c] = grey
+		stack = append(stack, c)
+		for _, s := range SortedClusters(succs[c]) {
+			switch color[s] {
+			case grey:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == s {
+						for _, c := range stack[i:] {
+							cycle = append(cycle, c.ImportPath)
+						}
+						cycle = append(cycle, s.ImportPath)
+						return true
+					}
+				}
+			case white:
+				if visit(s) {
+					return true
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		color[c] = black
+		return false
+	}
+	for _, c := range clusters {
+		if color[c] == white && visit(c) {
+			return cycle
+		}
+	}
+	return nil
+}
+
+func (g *Graph) addResidualCluster(clusters []*Cluster) []*Cluster {
+	// The final cluster, residue, includes all other nodes.
+	c := &Cluster{
+		ID:         len(clusters),
+		ImportPath: g.Options.ResiduePath,
+		Nodes:      make(map[*Node]bool),
+		residue:    true,
+	}
+	g.logf(1, "# cluster %s\n", c.ImportPath)
+	for _, n := range g.Nodes {
+		if n.Cluster == nil {
+			n.Cluster = c
+			g.logf(2, "\t%s\n", n)
+			c.Nodes[n] = true
+		}
+	}
+	c.finish(g)
+	if len(c.Nodes) > 0 {
+		clusters = append(clusters, c)
+	}
+	return clusters
+}
+
+// SortedClusters returns the elements of set in id order.
+func SortedClusters(set map[*Cluster]bool) []*Cluster {
+	res := make([]*Cluster, 0, len(set))
+	for c := range set {
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// CheckImportPath returns a description of what is wrong with the
+// import path of a cluster, or "" if it is valid.
+func CheckImportPath(importPath string) string {
+	if importPath == "" {
+		return "empty"
+	}
+	if strings.HasPrefix(importPath, "/") || strings.HasSuffix(importPath, "/") ||
+		strings.Contains(importPath, "//") {
+		return "empty path segment"
+	}
+	for _, seg := range strings.Split(importPath, "/") {
+		if seg == "." || seg == ".." {
+			return "relative path segment"
+		}
+	}
+	if name := PackageName(importPath); !token.IsIdentifier(name) || name == "_" {
+		return fmt.Sprintf("%q is not a valid package name", name)
+	}
+	return ""
+}
+
+// PackageName returns the package name of the cluster of the
+// specified import path: its last segment, with each character not
+// allowed in an identifier, such as '-' or '.', replaced by '_'.
+func PackageName(importPath string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, path.Base(importPath))
+}
@@ -0,0 +1,63 @@
+package analysis_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/arl/sockdrawer/analysis"
+	"github.com/arl/sockdrawer/internal/analysistest"
+)
+
+// Each partition starts afresh from the node graph, undoing the method
+// rebindings and detachments of the previous clusters file, as when
+// sockdrawer -watch reloads it.
+func TestRepartition(t *testing.T) {
+	g := analysistest.LoadSource(t, analysis.Options{}, map[string]string{"p.go": `package p
+
+type T int
+
+func (T) m() {}
+
+type U int
+`})
+	analysistest.Partition(t, g, "= p/low\nU\n(T).m -> U\n")
+	for _, test := range []struct{ name, succs string }{
+		{"T", ""},
+		{"(T).m", "T U"},
+		{"U", "(T).m"},
+	} {
+		if got := analysistest.SuccNames(analysistest.LookupNode(t, g, test.name)); got != test.succs {
+			t.Errorf("successors of %s = %q, want %q", test.name, got, test.succs)
+		}
+	}
+
+	analysistest.Partition(t, g, "= p/low\nT\n")
+	for _, test := range []struct{ name, succs, cluster string }{
+		{"T", "(T).m", "p/low"},
+		{"(T).m", "T", "p/low"},
+		{"U", "", "residue"},
+	} {
+		n := analysistest.LookupNode(t, g, test.name)
+		if got := analysistest.SuccNames(n); got != test.succs {
+			t.Errorf("successors of %s = %q, want %q", test.name, got, test.succs)
+		}
+		if got := n.Cluster.ImportPath; got != test.cluster {
+			t.Errorf("cluster of %s = %s, want %s", test.name, got, test.cluster)
+		}
+	}
+}
+
+// Warnings about the clusters file go to Options.Log.
+func TestPartitionLog(t *testing.T) {
+	var buf bytes.Buffer
+	g := analysistest.LoadSource(t, analysis.Options{Log: &buf}, map[string]string{"p.go": "package p\n\nfunc f() {}\n"})
+	filename := analysistest.WriteClusters(t, "= p/low\nf\ng\n")
+	if _, err := g.Partition(filename); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("%s:3: warning: can't find node \"g\"; ignoring\n", filename)
+	if got := buf.String(); got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package analysis
+
+// This file defines colocation (Options.Colocate), which infers
+// cluster assignments from grouped declarations: the members of a
+// const(...), var(...), or type(...) group are usually closely
+// related, and are best kept in the same cluster.
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// declGroups returns the node sets of the parenthesized declaration
+// groups with more than one member, in lexical order.
+func (g *Graph) declGroups() [][]*Node {
+	bySyntax := make(map[ast.Node]*Node)
+	for _, n := range g.Nodes {
+		bySyntax[n.Syntax] = n
+	}
+	var groups [][]*Node
+	for _, f := range g.Info.Files {
+		for _, decl := range f.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok == token.IMPORT || !decl.Lparen.IsValid() {
+				continue
+			}
+			var group []*Node
+			for _, spec := range decl.Specs {
+				if n := bySyntax[spec]; n != nil {
+					group = append(group, n)
+				}
+			}
+			if len(group) > 1 {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups
+}
+
+// colocate assigns each unassigned member of a declaration group to
+// the cluster of the group's assigned members, if they all belong to
+// the same one, together with the unassigned nodes it reaches, which
+// would otherwise go to the residue.  An assignment is made only if
+// it creates no reference from the cluster to a later cluster or the
+// residue.  Each node so assigned is marked as colocated.
+func (g *Graph) colocate(clusters []*Cluster) {
+	groups := g.declGroups()
+	for changed := true; changed; {
+		changed = false
+		for _, group := range groups {
+			var c *Cluster
+			for _, n := range group {
+				if n.Cluster != nil {
+					if c != nil && n.Cluster != c {
+						c = nil
+						break // a group divided by the clusters file
+					}
+					c = n.Cluster
+				}
+			}
+			if c == nil {
+				continue
+			}
+			for _, n := range group {
+				if n.Cluster == nil && g.colocateNode(n, c) {
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+// colocateNode assigns n, and the unassigned nodes it reaches, to
+// cluster c, and reports whether it did so.  It does nothing if
+// these nodes include a pinned node or depend on a node of a cluster
+// declared after c.
+func (g *Graph) colocateNode(n *Node, c *Cluster) bool {
+	reached := map[*Node]bool{n: true}
+	for work := []*Node{n}; len(work) > 0; {
+		m := work[len(work)-1]
+		work = work[:len(work)-1] // pop
+		if m.IsPinned() {
+			return false
+		}
+		for succ := range m.Succs {
+			switch {
+			case succ.Cluster == nil:
+				if !reached[succ] {
+					reached[succ] = true
+					work = append(work, succ)
+				}
+			case succ.Cluster.ID > c.ID:
+				return false // upward reference
+			}
+		}
+	}
+	for m := range reached {
+		m.Cluster = c
+		m.Colocated = true
+		c.Nodes[m] = true
+		g.logf(2, "\t%-50s (colocated)\n", m)
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package analysis
+
+// This file defines the merging of the node graphs of a package under
+// several build configurations.
+
+// A ConfigGraph is the node graph of the package
+// under a secondary build configuration, e.g. "linux/amd64".
+type ConfigGraph struct {
+	Config string
+	Graph  *Graph
+}
+
+// MergeConfigs merges the node graphs of the secondary configurations
+// into g, which is that of the primary configuration.  Each edge
+// between nodes of the same names is added to g.  Nodes absent
+// from g are recorded in g.Absent, and the paths through them
+// become edges: A -> X -> B, where X is absent, adds A -> B.
+func (g *Graph) MergeConfigs(primary string, graphs []*ConfigGraph) {
+	g.Configs = []string{primary}
+	byName := make(map[string]*Node)
+	for _, n := range g.Nodes {
+		n.Configs = []string{primary}
+		byName[n.Name] = n
+	}
+	g.Absent = make(map[string][]string)
+	for _, cg := range graphs {
+		g.Configs = append(g.Configs, cg.Config)
+		for _, n2 := range cg.Graph.Nodes {
+			n := byName[n2.Name]
+			if n == nil {
+				g.Absent[n2.Name] = append(g.Absent[n2.Name], cg.Config)
+				continue
+			}
+			n.Configs = append(n.Configs, cg.Config)
+			seen := make(map[*Node]bool)
+			var visit func(n2 *Node)
+			visit = func(n2 *Node) {
+				for succ2 := range n2.Succs {
+					if seen[succ2] {
+						continue
+					}
+					seen[succ2] = true
+					if succ := byName[succ2.Name]; succ != nil {
+						AddEdge(n, succ)
+					} else {
+						visit(succ2) // absent
+					}
+				}
+			}
+			visit(n2)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"github.com/arl/sockdrawer/analysis"
+	"github.com/arl/sockdrawer/internal/analysistest"
+)
+
+// A path through nodes absent from the primary configuration becomes
+// an edge between the primary nodes at its ends.
+func TestMergeConfigsAbsent(t *testing.T) {
+	g := analysistest.LoadSource(t, analysis.Options{}, map[string]string{
+		"p.go": `package p
+
+func A() { hook() }
+
+func B() {}
+`,
+		"hook_linux.go": `package p
+
+func hook() {}
+`})
+	windows := analysistest.LoadSource(t, analysis.Options{}, map[string]string{
+		"p.go": `package p
+
+func A() { hook() }
+
+func B() {}
+`,
+		"hook_windows.go": `package p
+
+var hook = winHook
+
+func winHook() { winHelper() }
+
+func winHelper() { B() }
+`})
+	g.MergeConfigs("linux/amd64", []*analysis.ConfigGraph{
+		{Config: "windows/amd64", Graph: windows},
+	})
+	for _, test := range []struct{ name, succs string }{
+		{"A", "hook"},
+		{"hook", "B"}, // through winHook and winHelper
+		{"B", ""},
+	} {
+		if got := analysistest.SuccNames(analysistest.LookupNode(t, g, test.name)); got != test.succs {
+			t.Errorf("successors of %s = %q, want %q", test.name, got, test.succs)
+		}
+	}
+	for _, name := range []string{"winHook", "winHelper"} {
+		if got := g.Absent[name]; len(got) != 1 || got[0] != "windows/amd64" {
+			t.Errorf("absent[%s] = %v, want [windows/amd64]", name, got)
+		}
+	}
+}
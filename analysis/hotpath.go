@@ -0,0 +1,61 @@
+package analysis
+
+// This file defines the binding of performance-critical nodes
+// (Options.HotPath), which are kept together like a type and its
+// methods.
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+// LoadHotNodes sets g.Hot to the set of nodes named in the specified
+// file, one per line, and binds them together (see BindHotNodes).
+// Blank lines and comments starting with '#' are ignored.
+func (g *Graph) LoadHotNodes(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]*Node)
+	for _, n := range g.Nodes {
+		byName[n.Name] = n
+	}
+	hot := make(map[*Node]bool)
+	for i, line := range strings.Split(string(data), "\n") {
+		if j := strings.IndexByte(line, '#'); j >= 0 {
+			line = line[:j] // strip comments
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue // skip blanks
+		}
+		if n := byName[line]; n != nil {
+			hot[n] = true
+		} else {
+			g.printf("%s:%d: warning: can't find node %q; ignoring\n",
+				filename, i+1, line)
+		}
+	}
+	g.Hot = hot
+	g.HotEdges = BindHotNodes(hot)
+	return nil
+}
+
+// BindHotNodes adds the reverse of each edge between hot nodes, so
+// that, like a type and its methods, they are kept together.
+// It returns the set of edges it added.
+func BindHotNodes(hot map[*Node]bool) map[Edge]bool {
+	added := make(map[Edge]bool)
+	for _, n := range SortedNodes(hot) {
+		for _, succ := range SortedNodes(n.Succs) {
+			if hot[succ] && !succ.Succs[n] {
+				added[Edge{succ, n}] = true
+			}
+		}
+	}
+	for e := range added {
+		AddEdge(e.From, e.To)
+	}
+	return added
+}
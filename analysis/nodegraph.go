@@ -0,0 +1,603 @@
+package analysis
+
+// This file defines Node and constructs the node graph.
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"hash/fnv"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+)
+
+// A Node represents a top-level declaration (including methods).
+// An entire const declaration is a single node.
+// An entire var or type "spec" is a single node.
+//
+// Examples:
+//
+//	func f()			// FuncDecl node
+//	func (T) f() {...}		// FuncDecl node (method)
+//	func init() {...} 		// FuncDecl node (no types.Object)
+//	type (
+//		T int			// TypeSpec node
+//		U int   		// TypeSpec node
+//	)
+//	type T int			// TypeDecl node
+//	const ( a, b = 0, 1; c = 0 )	// GenDecl(CONST) node (multiple objects)
+//	var x = f()			// GenDecl(VAR) node
+//	var x, y = f()   		// GenDecl(VAR) node (multiple objects)
+//	var _ T = C(0)			// GenDecl(VAR) node (no object)
+type Node struct {
+	Graph        *Graph
+	ID           int                         // zero-based ordinal, lexical order
+	Name         string                      // unique name, as used in clusters file
+	legacyName   string                      // former sequential name of an anonymous node
+	Syntax       ast.Node                    // ast.Decl, or ast.Spec if var/type in group
+	Uses         map[*ast.Ident]types.Object // uses of pkg- and file-scope objects
+	Objects      []types.Object              // declared objects in lexical order; blanks omitted
+	Recv         types.Type                  // receiver  type, iff concrete method decl
+	Succs, Preds map[*Node]bool              // node graph adjacency sets
+	SCC          *SCNode                     // SCC to which this node belongs
+	Cluster      *Cluster                    // cluster to which this node belongs
+	XTest        bool                        // declared in the external test package
+	Configs      []string                    // build configurations declaring n (MergeConfigs)
+	Colocated    bool                        // cluster inferred from a declaration group (Options.Colocate)
+
+	// The attributes derived from the syntax and objects, which a
+	// graph read from a cache retains without them; see ReadCache.
+	pos, end token.Pos
+	kind     string                // see Kind
+	funcName string                // name of a func decl, e.g. "init"
+	names    []string              // names of Objects
+	grouped  bool                  // a spec of a parenthesized group
+	recv     string                // receiver type of a concrete method, e.g. "*T"
+	recvNode *Node                 // see RecvNode
+	exported bool                  // declares an exported object
+	iface    bool                  // declares an interface type
+	refs     map[*Node][]token.Pos // positions of the references to each successor, in order
+}
+
+func (n *Node) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(n.Name)
+	if nobj := len(n.names); nobj > 1 {
+		fmt.Fprintf(&buf, " + %d", nobj-1)
+	}
+	return buf.String()
+}
+
+// IsTest reports whether n is declared in a _test.go file.
+// Such nodes (present only with Options.Tests) appear in the analysis
+// but not in the refactored output.
+func (n *Node) IsTest() bool {
+	return n.XTest || strings.HasSuffix(n.Filename(), "_test.go")
+}
+
+// Filename returns the base name of the file declaring n.
+func (n *Node) Filename() string {
+	return filepath.Base(n.Graph.Fset.Position(n.pos).Filename)
+}
+
+// Pos returns the position of the start of n's declaration.
+func (n *Node) Pos() token.Pos { return n.pos }
+
+// End returns the position of the end of n's declaration.
+func (n *Node) End() token.Pos { return n.end }
+
+// Refs returns the positions of the references of n to succ, one of
+// its successors, in order.  Synthetic edges, such as those from
+// receiver types to their methods, have none.
+func (n *Node) Refs(succ *Node) []token.Pos { return n.refs[succ] }
+
+// SortedUses returns the identifiers of n.Uses in order of position.
+func (n *Node) SortedUses() []*ast.Ident {
+	ids := make([]*ast.Ident, 0, len(n.Uses))
+	for id := range n.Uses {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Pos() < ids[j].Pos() })
+	return ids
+}
+
+// Exportedness returns 1 if n declares an exported object, 0 otherwise.
+func (n *Node) Exportedness() int {
+	if n.exported {
+		return 1
+	}
+	return 0
+}
+
+// Doc returns the text of n's doc comment, or "" if it has none.
+func (n *Node) Doc() string {
+	var doc *ast.CommentGroup
+	switch syntax := n.Syntax.(type) {
+	case *ast.FuncDecl:
+		doc = syntax.Doc
+	case *ast.GenDecl:
+		doc = syntax.Doc
+	case *ast.TypeSpec:
+		doc = syntax.Doc
+	case *ast.ValueSpec:
+		doc = syntax.Doc
+	}
+	return doc.Text()
+}
+
+// IsPinned reports whether n is a special function that can't be
+// moved out of the package: main (in package main), TestMain, and,
+// with Options.PinInit, init functions, since moving them changes the
+// order of initialization.
+func (n *Node) IsPinned() bool {
+	if n.kind != "func" {
+		return false
+	}
+	switch n.funcName {
+	case "main":
+		return n.Graph.Info.Pkg.Name() == "main"
+	case "TestMain":
+		return true
+	case "init":
+		return n.Graph.Options.PinInit
+	}
+	return false
+}
+
+// Kind returns the kind of declaration of n:
+// "func", "method", "const", "var" or "type".
+func (n *Node) Kind() string { return n.kind }
+
+// Names returns the names of the objects declared by n, in lexical
+// order, unqualified even in the external test package.
+func (n *Node) Names() []string { return n.names }
+
+// IsGrouped reports whether n is a spec of a parenthesized declaration
+// group, such as x in "var (x int; y int)", so that its position is that
+// of its first name rather than of the var or type keyword.
+func (n *Node) IsGrouped() bool { return n.grouped }
+
+// IsMethod reports whether n is a concrete method.
+func (n *Node) IsMethod() bool { return n.recv != "" }
+
+// RecvType returns the receiver type of n, if a concrete method,
+// relative to its package, e.g. "*T" or "List[T]"; otherwise "".
+func (n *Node) RecvType() string { return n.recv }
+
+// kindOf returns the kind of the declaration syntax; see Node.Kind.
+func kindOf(syntax ast.Node) string {
+	switch syntax := syntax.(type) {
+	case *ast.FuncDecl:
+		if syntax.Recv != nil {
+			return "method"
+		}
+		return "func"
+	case *ast.TypeSpec:
+		return "type"
+	case *ast.ValueSpec:
+		return "var"
+	case *ast.GenDecl:
+		return syntax.Tok.String()
+	}
+	return ""
+}
+
+// hasKind reports whether n is of the specified kind, as reported by
+// Kind, or "interface" for declarations of interface types.
+func (n *Node) hasKind(kind string) bool {
+	if kind == "interface" {
+		return n.iface
+	}
+	return n.kind == kind
+}
+
+// IsIsolated reports whether n has no edges.
+func (n *Node) IsIsolated() bool {
+	return len(n.Succs) == 0 && len(n.Preds) == 0
+}
+
+// isType reports whether n is a type declaration.
+func (n *Node) isType() bool { return n.kind == "type" }
+
+// dotImports returns the package names declared by the dot imports
+// of each file, by imported package.
+func (g *Graph) dotImports() map[*token.File]map[*types.Package]*types.PkgName {
+	dots := make(map[*token.File]map[*types.Package]*types.PkgName)
+	for _, info := range []*loader.PackageInfo{g.Info, g.XTest} {
+		if info == nil {
+			continue
+		}
+		for _, f := range info.Files {
+			for _, imp := range f.Imports {
+				if imp.Name == nil || imp.Name.Name != "." {
+					continue
+				}
+				if pkgName, ok := info.Defs[imp.Name].(*types.PkgName); ok {
+					file := g.Fset.File(f.Pos())
+					if dots[file] == nil {
+						dots[file] = make(map[*types.Package]*types.PkgName)
+					}
+					dots[file][pkgName.Imported()] = pkgName
+				}
+			}
+		}
+	}
+	return dots
+}
+
+// AddEdge adds an edge from one node to another.
+func AddEdge(from, to *Node) {
+	if from == to {
+		return // skip self-edges
+	}
+	from.Succs[to] = true
+	to.Preds[from] = true
+}
+
+func (g *Graph) buildNodeGraph() {
+	g.logf(1, "==== %s ====\n", g.Info.Pkg.Path())
+
+	// -- Pass 1: Defs ----------------------------------------------------
+
+	g.addDeclNodes(g.Info, false)
+	if g.XTest != nil {
+		// With Options.Tests, the external test package contributes
+		// a second set of nodes, named with a package prefix.
+		g.addDeclNodes(g.XTest, true)
+	}
+
+	// -- Pass 2: Refs ----------------------------------------------------
+
+	// Gather references from this syntax tree to other
+	// top-level trees, and create graph edges for them.
+	// (Also gather refs to existing import names in 'uses'.)
+	// An embedded interface or struct type is an ordinary use
+	// of its type name, so embedding creates an edge too.
+	// Type parameters are not package-level, so their uses
+	// create no edges; a use of a method of an instantiated
+	// generic type is a use of the generic method.
+	// An unqualified use of another package's object is a use of
+	// the name of its dot import, recorded as such.
+	dots := g.dotImports()
+	for _, n := range g.Nodes {
+		info := g.Info
+		if n.XTest {
+			info = g.XTest
+		}
+		qualified := make(map[*ast.Ident]bool) // the f of each pkg.f
+		ast.Inspect(n.Syntax, func(syntax ast.Node) bool {
+			if sel, ok := syntax.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok {
+					if _, ok := info.Uses[x].(*types.PkgName); ok {
+						qualified[sel.Sel] = true
+					}
+				}
+			}
+			if id, ok := syntax.(*ast.Ident); ok {
+				if obj, ok := info.Uses[id]; ok {
+					obj = OriginObj(obj)
+					if obj.Pkg() == nil {
+						// universe object, e.g. error or len
+					} else if n2, ok := g.NodesByObj[obj]; ok {
+						AddEdge(n, n2)
+						n.Uses[id] = obj
+						n.refs[n2] = append(n.refs[n2], id.Pos())
+					} else if _, ok := obj.(*types.PkgName); ok {
+						n.Uses[id] = obj
+					} else if dot := dots[g.Fset.File(id.Pos())][obj.Pkg()]; dot != nil &&
+						IsPackageLevel(obj) && !qualified[id] {
+						n.Uses[id] = dot
+					}
+				}
+			}
+			return true
+		})
+
+		// To ensure methods and receiver types stay together,
+		// we add edges to each method from its receiver type.
+		if n.Recv != nil {
+			if t := n.lookupRecvNode(); t != nil {
+				n.recvNode = t
+				AddEdge(t, n)
+				g.recvEdges = append(g.recvEdges, Edge{t, n})
+			} else {
+				g.logf(1, "%s: can't find receiver type %s of %s; not keeping them together\n",
+					g.Fset.Position(n.pos), n.Recv, n.Name)
+			}
+		}
+	}
+
+	g.logf(1, "\t%d nodes\n", len(g.Nodes))
+}
+
+// addDeclNodes adds a node for each top-level declaration
+// in the files of the specified package.
+func (g *Graph) addDeclNodes(info *loader.PackageInfo, xtest bool) {
+	for _, f := range info.Files {
+		// These vars are used for generating symbol names:
+		// e.g. "func$alg.5f3a09c2", for an init function in runtime/alg.go,
+		// formerly "func$alg.3", if it was the third such function.
+		base := strings.TrimSuffix(filepath.Base(g.Fset.Position(f.Pos()).Filename), ".go")
+		var seq int
+		used := make(map[string]int)
+
+		ForEachDecl(f, func(syntax ast.Node, parent *ast.GenDecl) {
+			n := &Node{
+				Graph:  g,
+				ID:     len(g.Nodes),
+				XTest:  xtest,
+				Syntax: syntax,
+				Uses:   make(map[*ast.Ident]types.Object),
+				Succs:  make(map[*Node]bool),
+				Preds:  make(map[*Node]bool),
+				pos:    syntax.Pos(),
+				end:    syntax.End(),
+				kind:   kindOf(syntax),
+				refs:   make(map[*Node][]token.Pos),
+			}
+			if decl, ok := syntax.(*ast.FuncDecl); ok && decl.Recv == nil {
+				n.funcName = decl.Name.Name
+			}
+			n.grouped = parent != nil
+
+			// Visit the top-level AST, associating with n
+			// every object declared within it that could
+			// possibly be references outside it, including:
+			// - package-level objects (const/func/var/type)
+			// - concrete methods
+			// - struct fields (consider y in "var x struct{y int}")
+			// - abstract methods (consider y in "var x interface{y()}")
+			ast.Inspect(syntax, func(syntax ast.Node) bool {
+				if id, ok := syntax.(*ast.Ident); ok {
+					// Definition of package-level object,
+					// or struct field or interface method?
+					if obj := info.Defs[id]; obj != nil {
+						if IsPackageLevel(obj) {
+							// package-level object
+							n.Objects = append(n.Objects, obj)
+						} else if v, ok := obj.(*types.Var); ok && v.IsField() {
+							// struct field
+						} else if _, ok := obj.(*types.Func); ok {
+							// method or init function
+							recv := MethodRecv(obj)
+							if recv != nil && !IsInterface(MethodRecv(obj)) {
+								// concrete method
+								n.Recv = recv
+								n.Objects = append(n.Objects, obj)
+							}
+						} else {
+							return true // ignore
+						}
+						g.NodesByObj[obj] = n
+					}
+				}
+				return true
+			})
+
+			// Name the node.
+			if n.Objects != nil {
+				// Only the first object (in lexical order) of a group
+				// (e.g. a const decl) is used for the node label.
+				n.Name = n.Objects[0].Name()
+
+				// concrete method decl?
+				if n.Recv != nil {
+					n.recv = types.TypeString(n.Recv, types.RelativeTo(info.Pkg))
+					n.Name = fmt.Sprintf("(%s).%s", n.recv, n.Name)
+				}
+				for _, obj := range n.Objects {
+					n.names = append(n.names, obj.Name())
+					n.exported = n.exported || obj.Exported()
+					if _, ok := obj.(*types.TypeName); ok && IsInterface(obj.Type()) {
+						n.iface = true
+					}
+				}
+			} else {
+				// e.g. blank identifier, or func init.
+				seq++
+				n.Name = defaultName(g.Fset, syntax, base)
+				if used[n.Name]++; used[n.Name] > 1 {
+					n.Name += fmt.Sprintf(".%d", used[n.Name]) // identical decls
+				}
+				n.legacyName = fmt.Sprintf("%s$%s.%d", anonKind(syntax), base, seq)
+			}
+			if xtest {
+				n.Name = info.Pkg.Name() + "." + n.Name
+			}
+
+			g.Nodes = append(g.Nodes, n)
+		})
+	}
+}
+
+// -- util -------------------------------------------------------------
+
+// defaultName invents a stable name for syntax, which declares no
+// named object, based on its kind and a hash of its content, so that
+// the name is unaffected by changes to other declarations.
+func defaultName(fset *token.FileSet, syntax ast.Node, base string) string {
+	// Ignore the doc comment.
+	switch decl := syntax.(type) {
+	case *ast.FuncDecl:
+		copy := *decl
+		copy.Doc = nil
+		syntax = &copy
+	case *ast.GenDecl:
+		copy := *decl
+		copy.Doc = nil
+		syntax = &copy
+	case *ast.ValueSpec:
+		copy := *decl
+		copy.Doc, copy.Comment = nil, nil
+		syntax = &copy
+	}
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, syntax)
+	h := fnv.New32a()
+	h.Write(bytes.Join(bytes.Fields(buf.Bytes()), []byte(" "))) // normalize space
+	return fmt.Sprintf("%s$%s.%08x", anonKind(syntax), base, h.Sum32())
+}
+
+// anonKind returns the kind of a declaration without a named object.
+func anonKind(syntax ast.Node) string {
+	// No object: func init, or blank identifier.
+	switch syntax := syntax.(type) {
+	case *ast.FuncDecl:
+		// e.g. func init()
+		return "func"
+	case *ast.ValueSpec:
+		// e.g. var ( _ int )
+		return "var"
+	case *ast.GenDecl:
+		switch syntax.Tok {
+		case token.CONST:
+			return "const" // e.g. const _ int
+		case token.VAR:
+			return "var" // e.g. var _ int
+		case token.TYPE:
+			return "type" // e.g. type _ int
+		}
+	}
+	// can't happen?
+	return reflect.TypeOf(syntax).String()
+}
+
+// ForEachDecl calls fn for each syntax tree (decl or spec) in the file
+// that should have its own node.  If syntax is a VarSpec or TypeSpec in
+// a group, parent is the enclosing decl.
+func ForEachDecl(file *ast.File, fn func(syntax ast.Node, parent *ast.GenDecl)) {
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			switch decl.Tok {
+			case token.CONST:
+				// treat decl as one node
+				fn(decl, nil)
+
+			case token.VAR, token.TYPE:
+				if decl.Lparen != 0 {
+					// group decl: each spec gets its own node
+					for _, spec := range decl.Specs {
+						fn(spec, decl)
+					}
+				} else {
+					// singleton: one node for entire decl
+					fn(decl, nil)
+				}
+			}
+
+		case *ast.FuncDecl:
+			// funcs (but not methods) get their own node
+			fn(decl, nil)
+		}
+	}
+}
+
+// RecvTypeName returns the declared type of a method receiver T or
+// *T.  For a generic type, List[T], it is that of the origin, List.
+// It returns false if T is neither, as in ill-typed code.
+func RecvTypeName(T types.Type) (*types.TypeName, bool) {
+	if ptr, ok := T.(*types.Pointer); ok {
+		T = ptr.Elem()
+	}
+	named, ok := T.(*types.Named)
+	if !ok {
+		return nil, false
+	}
+	return named.Origin().Obj(), true
+}
+
+// RecvNode returns the node declaring the receiver type of n, or nil
+// if n is not a concrete method or its receiver type is unknown.
+func (n *Node) RecvNode() *Node { return n.recvNode }
+
+// lookupRecvNode returns the node declaring the receiver type of n,
+// by its object; see RecvNode.
+func (n *Node) lookupRecvNode() *Node {
+	if n.Recv == nil {
+		return nil
+	}
+	tn, ok := RecvTypeName(n.Recv)
+	if !ok {
+		return nil
+	}
+	return n.Graph.NodesByObj[tn]
+}
+
+// OriginObj returns the declared object of which obj is an instance:
+// the generic method or field, if obj is a method or field of an
+// instantiation of a generic type, such as List[int].Push or
+// List[int].x; otherwise obj itself.
+// (The uses of generic functions already refer to their declarations.)
+func OriginObj(obj types.Object) types.Object {
+	if v, ok := obj.(*types.Var); ok && v.IsField() {
+		// types.Var.Origin is new in go1.19.
+		if v, ok := obj.(interface{ Origin() *types.Var }); ok {
+			return v.Origin()
+		}
+		return obj
+	}
+	recv := MethodRecv(obj)
+	if recv == nil {
+		return obj
+	}
+	if ptr, ok := recv.(*types.Pointer); ok {
+		recv = ptr.Elem()
+	}
+	named, ok := recv.(*types.Named)
+	if !ok || named.Origin() == named {
+		return obj
+	}
+	orig := named.Origin()
+	for i := 0; i < orig.NumMethods(); i++ {
+		if m := orig.Method(i); m.Name() == obj.Name() {
+			return m
+		}
+	}
+	return obj
+}
+
+// MethodRecv returns the receiver type of obj,
+// if it's a method, or nil otherwise.
+// TODO(adonovan): move this to go/types.  It gets re-invented a lot.
+func MethodRecv(obj types.Object) types.Type {
+	if obj, ok := obj.(*types.Func); ok {
+		recv := obj.Type().(*types.Signature).Recv()
+		if recv != nil {
+			return recv.Type()
+		}
+	}
+	return nil
+}
+
+// IsInterface reports whether T's underlying type is an interface.
+func IsInterface(T types.Type) bool {
+	_, ok := T.Underlying().(*types.Interface)
+	return ok
+}
+
+// An Edge is an edge of the node graph.
+type Edge struct{ From, To *Node }
+
+// SortedNodes returns the elements of set in id order.
+func SortedNodes(set map[*Node]bool) []*Node {
+	res := make([]*Node, 0, len(set))
+	for n := range set {
+		res = append(res, n)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// IsPackageLevel reports whether obj is declared at package level.
+// Universe objects (error, len, etc) have no package and are not.
+func IsPackageLevel(obj types.Object) bool {
+	return obj.Pkg() != nil && obj.Pkg().Scope().Lookup(obj.Name()) == obj
+}
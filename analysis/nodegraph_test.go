@@ -0,0 +1,121 @@
+package analysis_test
+
+import (
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/arl/sockdrawer/analysis"
+	"github.com/arl/sockdrawer/internal/analysistest"
+)
+
+// An embedded interface is a use of its type name, so each interface
+// of an embedding chain depends on the next, and a cluster holding one
+// holds the rest of the chain below it.
+func TestInterfaceEmbedding(t *testing.T) {
+	g := analysistest.LoadSource(t, analysis.Options{}, map[string]string{"p.go": `package p
+
+type A interface {
+	B
+	a()
+}
+
+type B interface {
+	C
+	b()
+}
+
+type C interface{ c() }
+`})
+	for _, test := range []struct{ name, succs string }{
+		{"A", "B"},
+		{"B", "C"},
+		{"C", ""},
+	} {
+		if got := analysistest.SuccNames(analysistest.LookupNode(t, g, test.name)); got != test.succs {
+			t.Errorf("successors of %s = %q, want %q", test.name, got, test.succs)
+		}
+	}
+
+	// The chain is acyclic: each interface is an SCC of its own.
+	g.SCGraph(false)
+	if a, b := analysistest.LookupNode(t, g, "A"), analysistest.LookupNode(t, g, "B"); a.SCC == b.SCC {
+		t.Errorf("A and B are in the same SCC")
+	}
+
+	analysistest.Partition(t, g, "= p/low\nB\n")
+	for name, want := range map[string]string{"A": "residue", "B": "p/low", "C": "p/low"} {
+		if got := analysistest.LookupNode(t, g, name).Cluster.ImportPath; got != want {
+			t.Errorf("cluster of %s = %s, want %s", name, got, want)
+		}
+	}
+}
+
+// References to universe objects, such as error, len and the Error
+// method of error, create neither nodes nor edges, even when the
+// package shadows other predeclared names.
+func TestUniverseReferences(t *testing.T) {
+	g := analysistest.LoadSource(t, analysis.Options{}, map[string]string{"p.go": `package p
+
+var cap = 3 // shadows the builtin
+
+func f(s []int) error {
+	if len(s) > cap {
+		return nil
+	}
+	var err error
+	_ = err.Error()
+	return err
+}
+
+func g() string { return f(nil).Error() }
+`})
+	var names []string
+	for _, n := range g.Nodes {
+		names = append(names, n.Name)
+	}
+	if got, want := strings.Join(names, " "), "cap f g"; got != want {
+		t.Errorf("nodes = %q, want %q", got, want)
+	}
+	for _, test := range []struct{ name, succs string }{
+		{"cap", ""},
+		{"f", "cap"},
+		{"g", "f"},
+	} {
+		if got := analysistest.SuccNames(analysistest.LookupNode(t, g, test.name)); got != test.succs {
+			t.Errorf("successors of %s = %q, want %q", test.name, got, test.succs)
+		}
+	}
+	if analysis.IsPackageLevel(types.Universe.Lookup("error")) {
+		t.Errorf("IsPackageLevel(error) = true")
+	}
+}
+
+// A use of a method or field of an instantiated generic type is a
+// use of the generic method or field.
+func TestGenericMethods(t *testing.T) {
+	g := analysistest.LoadSource(t, analysis.Options{}, map[string]string{"p.go": `package p
+
+type List[T any] struct{ x []T }
+
+func (l *List[T]) Push(v T) { l.x = append(l.x, v) }
+
+func (l *List[T]) len() int { return len(l.x) }
+
+func use() int {
+	var l List[int]
+	l.Push(1)
+	return l.len() + len(l.x)
+}
+`})
+	for _, test := range []struct{ name, succs string }{
+		{"List", "(*List[T]).Push (*List[T]).len"},
+		{"(*List[T]).Push", "List"},
+		{"(*List[T]).len", "List"},
+		{"use", "List (*List[T]).Push (*List[T]).len"},
+	} {
+		if got := analysistest.SuccNames(analysistest.LookupNode(t, g, test.name)); got != test.succs {
+			t.Errorf("successors of %s = %q, want %q", test.name, got, test.succs)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package analysis
+
+// This file defines the strong-component graph.
+// (It is used only to simplify the renderings.)
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// An SCNode is a node in the scnode graph.
+// It is (approximately; see SCGraph) an SCC of the node graph.
+type SCNode struct {
+	ID           int              // unique id
+	Nodes        map[*Node]bool   // elements of this SCC
+	Succs, Preds map[*SCNode]bool // scnode graph adjacency sets
+	Cluster      *Cluster         // the cluster to which this SCC belongs
+}
+
+const maxLines = 8 // maximum number of lines in a label
+
+func (s *SCNode) String() string {
+	var buf bytes.Buffer
+	// Order nodes by exportedness and in-degree.
+	order := make([]*Node, 0, len(s.Nodes))
+	for n := range s.Nodes {
+		order = append(order, n)
+	}
+	sort.Sort(ByExportednessAndInDegree(order))
+	for i, n := range order {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		if i == maxLines-1 && len(order) > maxLines {
+			fmt.Fprintf(&buf, "+ %d more", len(order)-i)
+			break
+		}
+		buf.WriteString(n.String())
+	}
+	return buf.String()
+}
+
+// exportedness returns 1 if any node of s is exported, 0 otherwise.
+func (s *SCNode) exportedness() int {
+	for n := range s.Nodes {
+		if n.Exportedness() > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// IsIsolated reports whether s is a single node without edges.
+func (s *SCNode) IsIsolated() bool {
+	if len(s.Nodes) != 1 {
+		return false
+	}
+	for n := range s.Nodes {
+		return n.IsIsolated()
+	}
+	return false
+}
+
+// ByExportednessAndInDegree orders nodes exported first, then by
+// decreasing in-degree.
+type ByExportednessAndInDegree []*Node
+
+func (b ByExportednessAndInDegree) Len() int { return len(b) }
+func (b ByExportednessAndInDegree) Less(i, j int) bool {
+	if r := b[i].Exportedness() - b[j].Exportedness(); r != 0 {
+		return r > 0
+	}
+	if r := len(b[i].Preds) - len(b[j].Preds); r != 0 {
+		return r > 0
+	}
+	return b[i].ID < b[j].ID
+}
+func (b ByExportednessAndInDegree) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+// SortedSCNodes returns the elements of set in id order.
+func SortedSCNodes(set map[*SCNode]bool) []*SCNode {
+	res := make([]*SCNode, 0, len(set))
+	for s := range set {
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// SCGraph returns the graph of the strongly connected components
+// of the node graph, or, with fuse, their fusion.
+// The scnodes are numbered deterministically: successors are visited
+// in id order, so the same input yields the same graph.
+func (g *Graph) SCGraph(fuse bool) map[*SCNode]bool {
+	// Kosaraju's algorithm---Tarjan is overkill here.
+
+	// Forward pass.
+	// The traversals use explicit stacks, not recursion,
+	// since the chains of dependencies in a large package
+	// may be deep enough to exhaust the goroutine stack.
+	S := make([]*Node, 0, len(g.Nodes)) // postorder stack
+	seen := make(map[*Node]bool)
+	type frame struct {
+		n     *Node
+		succs []*Node // successors not yet visited
+	}
+	var stack []frame
+	push := func(n *Node) {
+		seen[n] = true
+		stack = append(stack, frame{n, SortedNodes(n.Succs)})
+	}
+	for _, n := range g.Nodes {
+		if seen[n] {
+			continue
+		}
+		push(n)
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if len(top.succs) == 0 {
+				S = append(S, top.n)
+				stack = stack[:len(stack)-1] // pop
+				continue
+			}
+			s := top.succs[0]
+			top.succs = top.succs[1:]
+			if !seen[s] {
+				push(s)
+			}
+		}
+	}
+
+	// Reverse pass.
+	var current *SCNode
+	seen = make(map[*Node]bool)
+	rvisit := func(d *Node) {
+		seen[d] = true
+		work := []*Node{d}
+		for len(work) > 0 {
+			d := work[len(work)-1]
+			work = work[:len(work)-1] // pop
+			current.Nodes[d] = true
+			d.SCC = current
+			for p := range d.Preds {
+				if !seen[p] {
+					seen[p] = true
+					work = append(work, p)
+				}
+			}
+		}
+	}
+	scnodes := make(map[*SCNode]bool)
+	for len(S) > 0 {
+		top := S[len(S)-1]
+		S = S[:len(S)-1] // pop
+		if !seen[top] {
+			current = &SCNode{
+				ID:      len(scnodes),
+				Cluster: top.Cluster,
+				Nodes:   make(map[*Node]bool),
+				Succs:   make(map[*SCNode]bool),
+				Preds:   make(map[*SCNode]bool),
+			}
+			rvisit(top)
+			scnodes[current] = true
+		}
+	}
+
+	// Build the strong-component DAG by
+	// projecting the edges of the node graph,
+	// discarding self-edges.
+	for s := range scnodes {
+		for n := range s.Nodes {
+			for pred := range n.Preds {
+				if s != pred.SCC {
+					s.Preds[pred.SCC] = true
+				}
+			}
+			for succ := range n.Succs {
+				if s != succ.SCC {
+					s.Succs[succ.SCC] = true
+				}
+			}
+		}
+	}
+
+	g.logf(1, "\t%d SCCs\n", len(scnodes))
+
+	// TODO(adonovan): do we still need this?
+	if fuse {
+		// Now fold each single-predecessor scnode into that predecessor.
+		// Iterate until a fixed point is reached.
+		//
+		// Example:  a -> b -> c
+		//                b -> d
+		// Becomes:  ab -> c
+		//           ab -> d
+		// Then:     abcd
+		//
+		// Since the loop conserves predecessor count for all
+		// non-deleted scnodes, the algorithm is order-invariant.
+		for {
+			var changed bool
+			for b := range scnodes {
+				if b == nil || len(b.Preds) != 1 {
+					continue
+				}
+				var a *SCNode
+				for a = range b.Preds {
+				}
+				// a is sole predecessor of b
+				if a.Cluster != b.Cluster {
+					// don't fuse SCCs belonging to different clusters!
+					continue
+				}
+				if g.Options.FuseExports && a.exportedness() != b.exportedness() {
+					// keep exported API boundaries visible
+					continue
+				}
+
+				changed = true
+
+				b.Preds = nil
+				delete(a.Succs, b)
+
+				// a gets all b's nodes
+				for n := range b.Nodes {
+					a.Nodes[n] = true
+					n.SCC = a
+				}
+				b.Nodes = nil
+
+				// a gets all b's succs
+				for c := range b.Succs {
+					a.Succs[c] = true
+					c.Preds[a] = true
+					delete(c.Preds, b)
+				}
+				b.Succs = nil
+
+				delete(scnodes, b)
+			}
+			if !changed {
+				break
+			}
+		}
+
+		g.logf(1, "\t%d SCCs (excluding single-predecessor ones)\n", len(scnodes))
+	}
+
+	return scnodes
+}
@@ -0,0 +1,40 @@
+package analysis_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// A long chain of dependencies does not exhaust the stack,
+// and each node of it is an SCC of its own.
+func TestLongChain(t *testing.T) {
+	const N = 100000
+	g := new(analysis.Graph)
+	for i := 0; i < N; i++ {
+		n := &analysis.Node{
+			Graph: g,
+			ID:    i,
+			Name:  fmt.Sprintf("f%d", i),
+			Succs: make(map[*analysis.Node]bool),
+			Preds: make(map[*analysis.Node]bool),
+		}
+		if i > 0 {
+			analysis.AddEdge(g.Nodes[i-1], n)
+		}
+		g.Nodes = append(g.Nodes, n)
+	}
+	scnodes := g.SCGraph(false)
+	if len(scnodes) != N {
+		t.Fatalf("got %d SCCs, want %d", len(scnodes), N)
+	}
+	for i, n := range g.Nodes {
+		if len(n.SCC.Nodes) != 1 {
+			t.Fatalf("SCC of %s has %d nodes", n.Name, len(n.SCC.Nodes))
+		}
+		if i > 0 && !g.Nodes[i-1].SCC.Succs[n.SCC] {
+			t.Fatalf("no SCC edge %s -> %s", g.Nodes[i-1].Name, n.Name)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+// This file checks that the exported API of the original package
+// remains accessible to external importers after the split (-verify-api).
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// checkAPI reports the exported package-level symbols of the
+// original package that the partition would make inaccessible to
+// external importers, and returns the number of them.  A symbol
+// remains accessible if it stays in the residue, which retains the
+// package's identity.  A symbol moved to a public subpackage is
+// accessible, though at a new import path, and is merely reported.
+// With -shims, a symbol that can be forwarded from the residue by a
+// shim remains accessible at its old import path.
+func (o *organizer) checkAPI(clusters []*analysis.Cluster) int {
+	residue := residueOf(clusters)
+	var unreachable int
+	for _, n := range o.Nodes {
+		if n.Recv != nil || n.IsTest() || n.Cluster.IsResidue() {
+			continue // methods follow their types; tests aren't API
+		}
+		for _, obj := range n.Objects {
+			if !obj.Exported() {
+				continue
+			}
+			posn := o.Fset.Position(obj.Pos())
+			if *shims && residue != nil {
+				if s, _ := o.makeShim(obj, residue); s != nil {
+					fmt.Fprintf(os.Stderr, "%s: note: %s moves to %s, forwarded by a shim\n",
+						posn, obj.Name(), n.Cluster.ImportPath)
+					continue
+				}
+			}
+			if isInternal(n.Cluster.ImportPath) {
+				unreachable++
+				fmt.Fprintf(os.Stderr, "%s: %s would become inaccessible in %s\n",
+					posn, obj.Name(), n.Cluster.ImportPath)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: note: %s moves to %s\n",
+					posn, obj.Name(), n.Cluster.ImportPath)
+			}
+		}
+	}
+	return unreachable
+}
+
+// isInternal reports whether the import path has an "internal"
+// segment, restricting the packages that may import it.
+func isInternal(importPath string) bool {
+	for _, seg := range strings.Split(importPath, "/") {
+		if seg == "internal" {
+			return true
+		}
+	}
+	return false
+}
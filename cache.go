@@ -0,0 +1,80 @@
+package main
+
+// This file defines -cache, which keeps the node graph between runs,
+// so that a run whose sources are unchanged need not load and
+// type-check the package.
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/tools/go/loader"
+)
+
+// sourceFiles returns the absolute names of the source files of the
+// initial packages of conf, set by FromArgs, including their tests
+// where those are wanted.
+func sourceFiles(conf *loader.Config) ([]string, error) {
+	cwd := conf.Cwd
+	if cwd == "" {
+		var err error
+		if cwd, err = os.Getwd(); err != nil {
+			return nil, err
+		}
+	}
+	ctxt := conf.Build
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+
+	var filenames []string
+	abs := func(dir, name string) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		filenames = append(filenames, name)
+	}
+	for _, cp := range conf.CreatePkgs {
+		for _, name := range cp.Filenames {
+			abs(cwd, name)
+		}
+	}
+	var paths []string
+	for path := range conf.ImportPkgs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		bp, err := ctxt.Import(path, cwd, 0)
+		if err != nil {
+			return nil, err
+		}
+		names := append(bp.GoFiles, bp.CgoFiles...)
+		if conf.ImportPkgs[path] {
+			names = append(names, bp.TestGoFiles...)
+			names = append(names, bp.XTestGoFiles...)
+		}
+		for _, name := range names {
+			abs(bp.Dir, name)
+		}
+	}
+	return filenames, nil
+}
+
+// canUseCache reports whether the flags call only for output that a
+// node graph read from the cache can support, which lacks syntax and
+// type information, and is of the primary build configuration alone.
+func canUseCache(configs []buildConfig) bool {
+	return len(configs) <= 1 &&
+		*outdir == "" &&
+		!*varAccessors &&
+		!*docs &&
+		!*colocate &&
+		!*apiSurface &&
+		!*exportReport &&
+		!*stats &&
+		!*verifyAPI &&
+		!*safety
+}
@@ -0,0 +1,273 @@
+package main
+
+// This file defines the checks of the partition.
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// checkPartition runs the checks of the partition that the flags
+// select, reporting each violation, and returns an error describing
+// the first kind found, if any.  A fresh run and a reload (-watch,
+// -repl) both apply it.
+func (o *organizer) checkPartition(clusters []*analysis.Cluster) error {
+	// Check the forbidden dependencies.
+	var failure error
+	if n := o.checkForbidden(clusters); n > 0 {
+		failure = fmt.Errorf("%d forbidden dependencies", n)
+	}
+
+	// Check that the cluster graph is acyclic.
+	if n := o.checkClusterCycle(clusters); n > 0 && failure == nil {
+		failure = fmt.Errorf("the cluster graph has a cycle of %d edges", n)
+	}
+
+	// Check for references from lower clusters to the residue.
+	if n := o.checkUpwardRefs(); n > 0 && failure == nil {
+		failure = fmt.Errorf("%d references from lower clusters to the residue", n)
+	}
+
+	// Check the order of the stanzas of the clusters file.
+	if n := checkStanzaOrder(clusters); n > 0 && *check && failure == nil {
+		failure = fmt.Errorf("%d nodes are claimed by clusters declared too early", n)
+	}
+
+	// Check for stanzas that assign no nodes.
+	if n := checkEmptyClusters(clusters); n > 0 && *strict && failure == nil {
+		failure = fmt.Errorf("%d clusters have no nodes", n)
+	}
+
+	// Check that the exported API remains accessible.
+	if *verifyAPI {
+		if *shims {
+			// Shims depend on the names of exported symbols.
+			if err := o.computeExports(clusters); err != nil {
+				return err
+			}
+		}
+		if n := o.checkAPI(clusters); n > 0 && failure == nil {
+			failure = fmt.Errorf("%d exported symbols would become inaccessible", n)
+		}
+	}
+
+	// Check the hot-path edges.
+	if n := o.checkHotEdges(o.Hot); n > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d hot-path edges cross cluster boundaries\n", n)
+	}
+
+	return failure
+}
+
+// checkForbidden reports each node-graph edge that violates a forbid
+// constraint, and returns the number of violations.
+func (o *organizer) checkForbidden(clusters []*analysis.Cluster) int {
+	byPath := make(map[string]*analysis.Cluster)
+	for _, c := range clusters {
+		byPath[c.ImportPath] = c
+	}
+	var violations int
+	for _, f := range o.Forbids {
+		from, to := byPath[f.From], byPath[f.To]
+		if from == nil || to == nil {
+			fmt.Fprintf(os.Stderr,
+				"%s:%d: warning: forbid directive names unknown cluster\n",
+				f.Line.Filename, f.Line.Linenum)
+			continue
+		}
+		for _, n := range o.Nodes {
+			if n.Cluster != from {
+				continue
+			}
+			for _, succ := range analysis.SortedNodes(n.Succs) {
+				if succ.Cluster != to {
+					continue
+				}
+				violations++
+				// Report each reference, in order, or the node
+				// itself if the edge is synthetic.
+				refs := n.Refs(succ)
+				for _, pos := range refs {
+					fmt.Fprintf(os.Stderr, "%s: forbidden dependency %s -> %s: %s refers to %s\n",
+						o.Fset.Position(pos), f.From, f.To, n.Name, succ.Name)
+				}
+				if refs == nil {
+					fmt.Fprintf(os.Stderr, "%s: forbidden dependency %s -> %s: %s depends on %s\n",
+						o.Fset.Position(n.Pos()), f.From, f.To, n.Name, succ.Name)
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// checkUpwardRefs reports each node-graph edge from a node in a
+// cluster other than the residue to a node in the residue, and
+// returns the number of them.  Since the residue is logically at the
+// top, such a reference would require an import cycle; it indicates
+// that the referring node was placed in a cluster that is too low.
+func (o *organizer) checkUpwardRefs() int {
+	var count int
+	for _, n := range o.Nodes {
+		if n.Cluster.IsResidue() {
+			continue
+		}
+		for _, succ := range analysis.SortedNodes(n.Succs) {
+			if succ.Cluster.IsResidue() {
+				count++
+				fmt.Fprintf(os.Stderr, "%s: error: %s in %s refers to %s in the residue\n",
+					o.Fset.Position(o.refPos(analysis.Edge{From: n, To: succ})),
+					n.Name, n.Cluster.ImportPath, succ.Name)
+			}
+		}
+	}
+	return count
+}
+
+// checkClusterCycle reports a cycle in the cluster graph, the
+// projection of the node graph onto clusters, if there is one, naming
+// an example edge between each pair of consecutive clusters along it.
+// It returns the number of edges of the cycle, or zero if the cluster
+// graph is a DAG.
+func (o *organizer) checkClusterCycle(clusters []*analysis.Cluster) int {
+	cycle := analysis.ClusterCycle(clusters)
+	if cycle == nil {
+		return 0
+	}
+	byPath := make(map[string]*analysis.Cluster)
+	for _, c := range clusters {
+		byPath[c.ImportPath] = c
+	}
+	fmt.Fprintf(os.Stderr, "error: the cluster graph is cyclic: %s\n", strings.Join(cycle, " -> "))
+	for i := 0; i+1 < len(cycle); i++ {
+		from, to := byPath[cycle[i]], byPath[cycle[i+1]]
+		for _, n := range analysis.SortedNodes(from.Nodes) {
+			var example *analysis.Node
+			for _, succ := range analysis.SortedNodes(n.Succs) {
+				if succ.Cluster == to {
+					example = succ
+					break
+				}
+			}
+			if example != nil {
+				fmt.Fprintf(os.Stderr, "%s: \t%s in %s refers to %s in %s\n",
+					o.Fset.Position(o.refPos(analysis.Edge{From: n, To: example})),
+					n.Name, from.ImportPath, example.Name, to.ImportPath)
+				break
+			}
+		}
+	}
+	return len(cycle) - 1
+}
+
+// isUnsplit reports whether the partition leaves every node in the
+// residue, even if the clusters file declares clusters, all empty.
+func isUnsplit(clusters []*analysis.Cluster) bool {
+	for _, c := range clusters {
+		if !c.IsResidue() && len(c.Nodes) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// checkEmptyClusters reports each cluster declared by the clusters
+// file to which its stanza assigned no nodes, for example because its
+// names are all mistyped or claimed by earlier clusters, and returns
+// the number of such clusters.  Their output packages would be empty.
+func checkEmptyClusters(clusters []*analysis.Cluster) int {
+	var count int
+	for _, c := range clusters {
+		if len(c.Nodes) == 0 && c.Stanza.Filename != "" {
+			fmt.Fprintf(os.Stderr, "%s:%d: warning: cluster %s has no nodes\n",
+				c.Stanza.Filename, c.Stanza.Linenum, c.ImportPath)
+			count++
+		}
+	}
+	return count
+}
+
+// checkStanzaOrder reports whether the clusters file declares its
+// stanzas out of bottom-to-top order, as evidenced by nodes named in
+// a stanza that were already claimed by a cluster declared earlier,
+// which depends on them.  If so, it suggests an order consistent with
+// both the dependencies and the stanzas' intent, if one exists, and
+// returns the number of such nodes.
+func checkStanzaOrder(clusters []*analysis.Cluster) int {
+	var count int
+	for _, c := range clusters {
+		count += len(c.Wanted)
+	}
+	if count == 0 {
+		return 0
+	}
+	if order := stanzaOrder(clusters); order != nil {
+		fmt.Fprintf(os.Stderr, "%s: warning: %d nodes are claimed by clusters declared too early; "+
+			"try declaring the clusters in this order: %s\n",
+			*clusterFile, count, strings.Join(order, ", "))
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: warning: %d nodes are claimed by clusters declared too early, "+
+			"but no order of the stanzas satisfies them all\n",
+			*clusterFile, count)
+	}
+	return count
+}
+
+// stanzaOrder returns the import paths of the clusters other than the
+// residue in an order in which each precedes the clusters that depend
+// on it, or that reach the nodes it wants, preferring the declared
+// order.  It returns nil if no such order exists.
+func stanzaOrder(clusters []*analysis.Cluster) []string {
+	deps := make(map[*analysis.Cluster][]*analysis.Cluster)
+	for _, c := range clusters {
+		for n := range c.Nodes {
+			for succ := range n.Succs {
+				if succ.Cluster != c {
+					deps[c] = append(deps[c], succ.Cluster)
+				}
+			}
+		}
+		for _, n := range c.Wanted {
+			deps[n.Cluster] = append(deps[n.Cluster], c)
+		}
+	}
+
+	const (
+		white = iota // unvisited
+		grey         // on the stack
+		black        // done
+	)
+	color := make(map[*analysis.Cluster]int)
+	var order []string
+	var visit func(c *analysis.Cluster) bool
+	visit = func(c *analysis.Cluster) bool {
+		switch color[c] {
+		case grey:
+			return false // cycle
+		case black:
+			return true
+		}
+		color[c] = grey
+		sort.Slice(deps[c], func(i, j int) bool { return deps[c][i].ID < deps[c][j].ID })
+		for _, dep := range deps[c] {
+			if !visit(dep) {
+				return false
+			}
+		}
+		color[c] = black
+		if !c.IsResidue() {
+			order = append(order, c.ImportPath)
+		}
+		return true
+	}
+	for _, c := range clusters {
+		if !visit(c) {
+			return nil
+		}
+	}
+	return order
+}
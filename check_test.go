@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// Violations of forbid directives are reported in order: by
+// successor, then by position.
+func TestForbiddenOrder(t *testing.T) {
+	o := loadSource(t, map[string]string{"p.go": `package p
+
+func a() {}
+func b() {}
+func c() {}
+
+func f() {
+	c()
+	b()
+	a()
+	b()
+	a()
+}
+`})
+	clusters := partitionWith(t, o, "= p/low\na\nb\nc\n= p/high\nf\nforbid: p/high -> p/low\n")
+	var n int
+	got := captureStderr(t, func() { n = o.checkForbidden(clusters) })
+	if n != 3 {
+		t.Errorf("checkForbidden = %d, want 3", n)
+	}
+	want := `p.go:10:2: forbidden dependency p/high -> p/low: f refers to a
+p.go:12:2: forbidden dependency p/high -> p/low: f refers to a
+p.go:9:2: forbidden dependency p/high -> p/low: f refers to b
+p.go:11:2: forbidden dependency p/high -> p/low: f refers to b
+p.go:8:2: forbidden dependency p/high -> p/low: f refers to c
+`
+	var lines []string
+	for _, line := range strings.SplitAfter(got, "\n") {
+		if i := strings.Index(line, "p.go:"); i >= 0 {
+			lines = append(lines, line[i:]) // strip the directory
+		}
+	}
+	if strings.Join(lines, "") != want {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(lines, ""), want)
+	}
+}
+
+// A partition whose declared clusters are all empty, for example
+// because their names are mistyped, leaves the package unsplit.
+func TestUnsplit(t *testing.T) {
+	o := loadSource(t, map[string]string{"p.go": "package p\n\nfunc f() {}\n"})
+	for _, test := range []struct {
+		clusters string
+		want     bool
+	}{
+		{"", true},
+		{"= p/low\ng\n", true},
+		{"= p/low\nf\n", false},
+	} {
+		var clusters []*analysis.Cluster
+		captureStderr(t, func() { clusters = partitionWith(t, o, test.clusters) })
+		if got := isUnsplit(clusters); got != test.want {
+			t.Errorf("isUnsplit(%q) = %t, want %t", test.clusters, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+// This file defines -clean, which removes the files of a previous
+// output that the current one lacks, such as those of a cluster since
+// renamed.  The output directory's manifest records the files that
+// sockdrawer wrote there, so that no other file is ever removed.
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifestName is the name of the manifest in the output directory.
+const manifestName = ".sockdrawer-files"
+
+// writeManifest records in the manifest the files of the output,
+// relative to the output directory.
+func writeManifest(files map[string]bool) error {
+	var lines []string
+	for file := range files {
+		lines = append(lines, filepath.ToSlash(file)+"\n")
+	}
+	sort.Strings(lines)
+	_, err := writeIfChanged(filepath.Join(*outdir, manifestName), []byte(strings.Join(lines, "")))
+	return err
+}
+
+// staleFiles returns the files of the previous output, per the
+// manifest, that are not among the specified files of the current
+// output, in order.  There are none if there is no manifest.  It
+// rejects a manifest naming a file outside the output directory.
+func staleFiles(files map[string]bool) ([]string, error) {
+	f, err := os.Open(filepath.Join(*outdir, manifestName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var stale []string
+	in := bufio.NewScanner(f)
+	for linenum := 1; in.Scan(); linenum++ {
+		file := filepath.FromSlash(in.Text())
+		if file == "" {
+			continue
+		}
+		if !isLocal(file) {
+			return nil, fmt.Errorf("%s:%d: %q is not within the output directory",
+				f.Name(), linenum, in.Text())
+		}
+		if !files[file] {
+			stale = append(stale, file)
+		}
+	}
+	sort.Strings(stale)
+	return stale, in.Err()
+}
+
+// isLocal reports whether the file name, relative to a directory,
+// denotes a file within it other than the directory itself.  It is
+// filepath.IsLocal, which needs Go 1.20, but for rejecting ".".
+func isLocal(file string) bool {
+	if filepath.IsAbs(file) || filepath.VolumeName(file) != "" {
+		return false
+	}
+	file = filepath.Clean(file)
+	return file != "." && file != ".." &&
+		!strings.HasPrefix(file, ".."+string(filepath.Separator))
+}
+
+// removeStale removes the stale files of the output directory (see
+// staleFiles), and the directories that they leave empty.
+func removeStale(files map[string]bool) error {
+	stale, err := staleFiles(files)
+	if err != nil {
+		return err
+	}
+	for _, file := range stale {
+		filename := filepath.Join(*outdir, file)
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "\tremoved %s\n", filename)
+		for dir := filepath.Dir(file); dir != "."; dir = filepath.Dir(dir) {
+			if entries, err := ioutil.ReadDir(filepath.Join(*outdir, dir)); err != nil || len(entries) > 0 {
+				break
+			}
+			if err := os.Remove(filepath.Join(*outdir, dir)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// removeStale removes the files of the manifest absent from the
+// current output, and the directories they leave empty, but no
+// other file.
+func TestRemoveStale(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, "outdir", dir)
+	write := func(name, content string) {
+		t.Helper()
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		return err == nil
+	}
+	write("a/a.go", "package a\n")
+	write("b/c/c.go", "package c\n")
+	write("b/notes.txt", "not ours\n")
+	write("d/d.go", "package d\n")
+	write(manifestName, "a/a.go\nb/c/c.go\nd/d.go\n")
+
+	current := map[string]bool{filepath.FromSlash("a/a.go"): true}
+	captureStderr(t, func() {
+		if err := removeStale(current); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, test := range []struct {
+		name string
+		want bool
+	}{
+		{"a/a.go", true},      // in the current output
+		{"b/c/c.go", false},   // stale
+		{"b/c", false},        // left empty
+		{"b/notes.txt", true}, // not in the manifest
+		{"b", true},           // not empty
+		{"d/d.go", false},     // stale
+		{"d", false},          // left empty
+	} {
+		if got := exists(test.name); got != test.want {
+			t.Errorf("%s exists = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+// A manifest naming a file outside the output directory is rejected
+// before any file is removed.
+func TestRemoveStaleEscape(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "out")
+	setFlag(t, "outdir", dir)
+	victim := filepath.Join(parent, "victim.go")
+	for _, entry := range []string{"../victim.go", "a/../../victim.go", filepath.ToSlash(victim), "."} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(victim, []byte("package victim\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		manifest := "a.go\n" + entry + "\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, manifestName), []byte(manifest), 0666); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		captureStderr(t, func() { err = removeStale(nil) })
+		if err == nil || !strings.Contains(err.Error(), "not within the output directory") {
+			t.Errorf("manifest entry %q: got error %v, want rejection", entry, err)
+		}
+		if _, err := os.Stat(victim); err != nil {
+			t.Errorf("manifest entry %q: %v", entry, err)
+		}
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("manifest entry %q: %v", entry, err)
+		}
+	}
+}
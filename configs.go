@@ -0,0 +1,85 @@
+package main
+
+// This file defines the loading of a package under several build
+// configurations (-configs).  The first configuration is primary: its
+// declarations form the node graph, and are refactored.  The node
+// graphs of the others are merged into it by node name (see
+// analysis.Graph.MergeConfigs), contributing their edges, and
+// recording which configurations declare each node.
+
+import (
+	"fmt"
+	"go/build"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/arl/sockdrawer/analysis"
+	"golang.org/x/tools/go/loader"
+)
+
+// A buildConfig is a target platform, e.g. linux/amd64.
+type buildConfig struct{ goos, goarch string }
+
+func (c buildConfig) String() string { return c.goos + "/" + c.goarch }
+
+// context returns a build context for the configuration.
+func (c buildConfig) context() *build.Context {
+	ctxt := build.Default
+	ctxt.GOOS = c.goos
+	ctxt.GOARCH = c.goarch
+	return &ctxt
+}
+
+// parseConfigs parses the value of -configs, a comma-separated list
+// of goos/goarch pairs.
+func parseConfigs(list string) ([]buildConfig, error) {
+	var configs []buildConfig
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		slash := strings.Index(s, "/")
+		if slash <= 0 || slash == len(s)-1 {
+			return nil, fmt.Errorf("invalid -configs entry %q; want goos/goarch", s)
+		}
+		configs = append(configs, buildConfig{s[:slash], s[slash+1:]})
+	}
+	return configs, nil
+}
+
+// loadConfigGraphs loads the packages of the primary loader
+// configuration, with the same settings, under each of the specified
+// build configurations, and builds their node graphs.
+func loadConfigGraphs(primary *loader.Config, configs []buildConfig) ([]*analysis.ConfigGraph, error) {
+	var graphs []*analysis.ConfigGraph
+	for _, c := range configs {
+		conf := *primary
+		conf.Fset = token.NewFileSet()
+		conf.Build = c.context()
+		iprog, err := conf.Load()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", c, err)
+		}
+		info, xtest := analysis.InitialPackages(iprog, *tests)
+		g := analysis.NewGraph(conf.Fset, info, xtest, options())
+		graphs = append(graphs, &analysis.ConfigGraph{Config: c.String(), Graph: g})
+	}
+	return graphs, nil
+}
+
+// printAbsent prints the names of the nodes declared only
+// under secondary configurations, and those configurations.
+func (o *organizer) printAbsent() {
+	if len(o.Absent) == 0 {
+		return
+	}
+	names := make([]string, 0, len(o.Absent))
+	for name := range o.Absent {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("# Absent from %s (not assignable):\n", o.Configs[0])
+	for _, name := range names {
+		fmt.Printf("# %-40s [only %s]\n", name, strings.Join(o.Absent[name], ","))
+	}
+	fmt.Println()
+}
@@ -0,0 +1,163 @@
+package main
+
+// This file checks for conflicts among the fields and methods of the
+// package's types that renaming for export would create.
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"os"
+	"sort"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// packageTypes returns the package-level named types of the package,
+// in lexical order.
+func (o *organizer) packageTypes() []*types.Named {
+	var res []*types.Named
+	scope := o.Info.Pkg.Scope()
+	for _, name := range scope.Names() {
+		if tn, ok := scope.Lookup(name).(*types.TypeName); ok && !tn.IsAlias() {
+			if named, ok := tn.Type().(*types.Named); ok {
+				res = append(res, named)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Obj().Pos() < res[j].Obj().Pos() })
+	return res
+}
+
+// lookup returns the field or method of T (or *T) of the specified name.
+func (o *organizer) lookup(T *types.Named, name string) types.Object {
+	var recv types.Type = T
+	if !analysis.IsInterface(T) {
+		recv = types.NewPointer(T)
+	}
+	obj, _, _ := types.LookupFieldOrMethod(recv, false, o.Info.Pkg, name)
+	return obj
+}
+
+// checkMethodConflicts reports the conflicts that renaming methods
+// for export (per exportNames) would create: a method whose new name
+// is that of another field or method of some type having the method,
+// whether declared directly or promoted from an embedded field; and
+// a type whose method is renamed differently from the corresponding
+// method of an interface that it implements, so that it would cease
+// to implement it.
+func (o *organizer) checkMethodConflicts(exportNames map[types.Object]string, prefix string) {
+	newName := func(obj types.Object) string {
+		if new, ok := exportNames[obj]; ok {
+			return new
+		}
+		return obj.Name()
+	}
+
+	var methods []*types.Func // renamed methods, concrete and abstract
+	for obj := range exportNames {
+		if f, ok := obj.(*types.Func); ok && analysis.MethodRecv(f) != nil {
+			methods = append(methods, f)
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Pos() < methods[j].Pos() })
+
+	seen := make(map[[2]types.Object]bool)
+	report := func(obj, prev types.Object, format string, args ...interface{}) {
+		if seen[[2]types.Object{obj, prev}] {
+			return
+		}
+		seen[[2]types.Object{obj, prev}] = true
+		fmt.Fprintf(os.Stderr, "%s: warning: exporting method %s as %s\n",
+			o.Fset.Position(obj.Pos()), obj.Name(), exportNames[obj])
+		fmt.Fprintf(os.Stderr, "%s: \t"+format+"\n",
+			append([]interface{}{o.Fset.Position(prev.Pos())}, args...)...)
+	}
+
+	named := o.packageTypes()
+	for _, T := range named {
+		// Concrete and promoted conflicts.
+		for _, m := range methods {
+			if o.lookup(T, m.Name()) != m {
+				continue // not a method of T
+			}
+			if prev := o.lookup(T, exportNames[m]); prev != nil && prev != m {
+				report(m, prev, "would conflict with %s of %s; rename one of them, e.g. to %s%s",
+					prev.Name(), T.Obj().Name(), prefix, exportNames[m])
+			}
+		}
+
+		// Abstract conflicts.
+		if analysis.IsInterface(T) {
+			continue
+		}
+		for _, I := range named {
+			iface, ok := I.Underlying().(*types.Interface)
+			if !ok || iface.NumMethods() == 0 ||
+				!types.Implements(types.NewPointer(T), iface) {
+				continue
+			}
+			for i := 0; i < iface.NumMethods(); i++ {
+				im := iface.Method(i)
+				cm := o.lookup(T, im.Name())
+				if newName(im) == newName(cm) {
+					continue
+				}
+				obj, prev := types.Object(im), cm
+				if _, ok := exportNames[cm]; ok {
+					obj, prev = cm, im
+				}
+				report(obj, prev, "but not %s, so %s would no longer implement %s; export both",
+					prev.Name(), T.Obj().Name(), I.Obj().Name())
+			}
+		}
+	}
+}
+
+// checkFieldConflicts finds each struct field that, renamed for export
+// (per exportNames), would have the name of another field of the same
+// struct, and adds the prefix to its new name, as for conflicting
+// package-level names.  An embedded field is named after its type, so
+// it can't be renamed; such conflicts are merely reported.
+func (o *organizer) checkFieldConflicts(exportNames map[types.Object]string, prefix string) {
+	for _, f := range o.Info.Files {
+		ast.Inspect(f, func(syntax ast.Node) bool {
+			st, ok := syntax.(*ast.StructType)
+			if !ok {
+				return true
+			}
+			T, ok := o.Info.TypeOf(st).(*types.Struct)
+			if !ok {
+				return true
+			}
+			fields := make(map[string]*types.Var) // by new name
+			for i := 0; i < T.NumFields(); i++ {
+				if field := T.Field(i); exportNames[field] == "" {
+					fields[field.Name()] = field
+				}
+			}
+			for i := 0; i < T.NumFields(); i++ {
+				field := T.Field(i)
+				name, ok := exportNames[field]
+				if !ok {
+					continue
+				}
+				if prev := fields[name]; prev != nil {
+					fmt.Fprintf(os.Stderr, "%s: warning: exporting field %s\n",
+						o.Fset.Position(field.Pos()), field.Name())
+					if field.Embedded() {
+						fmt.Fprintf(os.Stderr, "%s: \twould conflict with %s; rename one of them.\n",
+							o.Fset.Position(prev.Pos()), name)
+						continue
+					}
+					fmt.Fprintf(os.Stderr, "%s: \twould conflict with %s; adding %q prefix.\n",
+						o.Fset.Position(prev.Pos()), name, prefix)
+					name = prefix + name
+					exportNames[field] = name
+				}
+				fields[name] = field
+			}
+			return true
+		})
+	}
+}
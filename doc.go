@@ -22,12 +22,24 @@ type declaration as a single node.
 	)
 	type ( x int; y int )			// a single type node
 
+A node is named after the first entity it declares, e.g. "a" above, but a
+clusters file may mention it by any of its names, e.g. "b".  Either way,
+the names of a node move together: they can't be assigned to different
+clusters, short of splitting the declaration by hand.
+
+A node that declares no named entity, such as func init or a var of the
+blank identifier, is named after its kind, file and a hash of its text,
+e.g. func$alg.5f3a09c2, so that its name is stable when other declarations
+change.  (The former sequential names, e.g. func$alg.3, are still accepted
+in clusters files, with a warning.)
+
 Each reference to a package-level entity E forms an edge in the node
 graph, from the node in which it appears to the node E.  For example:
 
 	var x int
 	var y = x 			// edge y -> x
 	func f() int { return y } 	// edge f -> y
+	type A interface { B; m() }	// edge A -> B (embedding)
 
 Each method declaration depends on its receiver named type; in addition
 we add an edge from each receiver type to its methods:
@@ -81,7 +93,8 @@ graph) from that cluster are assigned to that cluster, if they have not
 yet been assigned to some other cluster.  Thus we need only mention the
 root nodes of the cluster, not all its internal nodes.  A warning is
 reported if a node mentioned in a stanza already belongs to a previously
-defined cluster.
+defined cluster, along with a suggested order of the stanzas that would
+avoid the conflict; the -check flag makes this an error.
 
 There is an implicit cluster, "residue", that holds all remaining nodes
 after the clusters defined by the file have been processed.  Initially,
@@ -89,20 +102,78 @@ when the clusters file is empty, the residue cluster contains the entire
 package.  (It is logically at the top.)  The task for the user is to
 iteratively define new clusters until the residue becomes empty.
 
+The -residue-path flag gives the residue an import path other than
+"residue", such as that of the original package, so that its output
+remains importable under the original name.
+
+Some declarations can't be moved and always remain in the residue:
+func main (in a main package), func TestMain, and, with -pin-init,
+init functions, since moving them would change the order of
+initialization.
+
+A concrete method normally stays with its receiver type.  An entry of
+the form "(*T).f -> U" detaches the method from T and binds it to the
+type U instead, so that it follows U into U's cluster.  Such methods
+will need manual conversion, since a method can't be declared in a
+package other than that of its receiver type.
+
+With -split-methods, a stanza may also name a method itself, as
+"(T).f" or "(*T).f", to assign it to that stanza's cluster rather than its
+receiver type's.  Declare the stanza after the type's, since the method
+still depends on its type.  A method so separated from its type is
+reported, since it too will need manual conversion, e.g. to a function.
+Without the flag, a stanza naming a method whose receiver type is, or
+will be, in another cluster is reported and the method left with its
+type.
+
+	(*T).f -> U
+
+An entry containing any of the characters *, ? or [ is a pattern, in
+the syntax of path.Match, that assigns all matching nodes not yet
+assigned.  A pattern may match a method by its name without parens or
+star, so "T.*" matches all the methods of T.  Pinned nodes that match
+are ignored; nodes that match but already belong to an earlier cluster
+are reported, as for named nodes.
+
+	parse*
+	T.*
+
+A directive of the form "forbid: A -> B" asserts that no node of
+cluster A may depend on a node of cluster B.  Each violating reference
+is reported, and the tool exits with a non-zero status.
+
+	forbid: mypkg/internal/util -> mypkg/internal/net
+
+A directive of the form "kind: K" within a stanza assigns to its cluster
+all unassigned nodes of kind K, one of const, func, interface, type or
+var.  This is a quick way to seed clusters by category of declaration.
+
+	kind: interface
+
+A directive of the form "@include file" reads the stanzas and
+directives of another clusters file at that point, so that several
+clusters files may share common definitions.  A relative name is
+resolved against the directory of the including file.  Warnings about
+included lines name the included file; cyclic inclusion is an error.
+
+	@include ../common.clusters
+
 
 Visualization
 
 When sockdrawer is run, it analyzes the source package, builds the node
 graph and the scgraph, loads the clusters file, computes the clusters for
-every node, and then emits SVG renderings of the three levels of graphs,
-with nodes colors coded as follows:
+every node, and then emits SVG renderings (or, with -graph-format, PNG or
+PDF) of the three levels of graphs, with nodes colors coded as follows:
 
 	green = cluster  (candidate subpackage)
 	pink  = scnode   (strong component of size > 1)
 	blue  = node     (func/type/var/const decl)
 
-The graphs of all clusters, a DAG, has green nodes; clicking one takes
-you to the graph over scnodes for that cluster, also a DAG.  Each pink
+The graphs of all clusters, a DAG, has green nodes, and edges that are
+red if the dependency requires some declaration to be exported, gray
+otherwise, and thicker the more node edges they represent.  Clicking a
+node takes you to the graph over scnodes for that cluster, also a DAG.  Each pink
 node in this graph represents a cyclical bunch of the node graph,
 collapsed together for ease of viewing.  Each blue node here represents a
 singleton SCC, a single declaration; singular SCCs are replaced by
@@ -110,24 +181,40 @@ their sole element for simplicity.
 
 Clicking a pink (plural) scnode shows the cyclical portion of the node
 graph that it represents.  (If the fusion optimization was enabled, it
-may not be fully cyclic.)  All of its nodes are blue.
+may not be fully cyclic.)  Its nodes are colored by kind of declaration:
+func lavender, method purple, type orange, var yellow and const green
+(the -kind-colors flag changes these).
+
+Clicking a declaration's node shows the definition of that node in godoc.
+(The godoc server's base URL is specified by the --godoc flag; for a
+pkgsite server, such as pkg.go.dev, add --godoc-style=pkgsite; for graphs
+to review offline, --godoc=none omits the links.)
 
-Clicking a blue node shows the definition of that node in godoc.
-(The godoc server's base URL is specified by the --godoc flag.)
+With --html, sockdrawer also assembles the graphs into a single page,
+sockdrawer.html, in which clicking a cluster or scnode opens its graph
+in a collapsible pane, so no file browsing is needed.
 
 
 Workflow
 
 Initially, all nodes belong to the "residue" cluster.  (GraphViz graph
-rendering can be slow for the first several iterations.  A large monitor
-is essential.)
+rendering can be slow for the first several iterations, so SCCs of more
+than -maxnodes nodes are rendered as placeholders.  A large monitor is
+essential.)
 
 The sockdrawer user's task when decomposing a package into clusters is
 to identify the lowest-hanging fruit (so to speak) in the residue
 cluster---a coherent group of related scnodes at the bottom of the
 graph---and to "snip off" a bunch at the "stem" by appending a new
 stanza to the clusters file and listing the roots of that bunch in the
-stanza, and then to re-run the tool.
+stanza, and then to re-run the tool.  With -watch, the tool stays
+resident and re-runs itself each time the clusters file is saved,
+without analyzing the package again.  Across runs, -cache=file keeps
+the node graph in a file, and reuses it until a source file changes.
+
+The -suggest flag proposes such bunches: for each, a stem scnode of the
+residue and everything below it that only the stem uses, printed as a
+stanza ready to paste into the clusters file.
 
 
 Nodes may be added to an existing stanza if appropriate, but if they are
@@ -135,37 +222,53 @@ added to a cluster that is "too low", this may create conflicts; keep an
 eye out for warnings.
 
 This process continues iteratively until the residue has become empty
-and the sets of clusters are satisfactory.
+and the sets of clusters are satisfactory.  Each run ends with a summary
+line on the standard error, giving the size of the residue and the
+percentage of the package partitioned.
 
 The tool prints the assignments of nodes to clusters: the "shopping
 list" for the refactoring work.  Clusters should be split off into
-subpackages in dependency order, lowest first.
+subpackages in dependency order, lowest first; the -order flag prints
+this order.  Before committing to a partition, weigh its cost in API
+surface: the -export-report flag lists the unexported identifiers that each
+pair of clusters forces to become exported.
+
+Other tools may use the analysis without this command: the package
+github.com/arl/sockdrawer/analysis builds the node graph of a package
+and partitions it by a clusters file (analysis.Analyze), while the
+command adds the reports, renderings and refactoring.
 
 
 Caveats
 
 The analysis chooses a single configuration, such as linux/amd64.
 Declarations for other configurations (e.g. windows/arm) will be absent
-from the node graph.
+from the node graph.  With -configs, the dependencies of other
+configurations are merged in, and declarations absent from the first
+are listed by -print, but only those of the first are refactored.
 
 There may be some excessively large SCCs in the node graph that reflect
 a circularity in the design.  For the purposes of analysis, you can
 break them arbitrarily by commenting out some code, though more thought
 will be required for a principled fix (e.g. dependency injection).
+The -scc-warn=N flag reports each SCC of more than N nodes, naming the
+nodes most likely to be holding it together, and -scc-cuts=node prints
+a small set of references whose removal would break up the SCC of that
+node: a plan for decoupling it.
 
 
 TODO
 
 - Document the refactoring.
-- Make pretty and stable names for anonymous nodes such as:
- 	func init() {...}
-	var _ int = ...
-  Currently their names are very sensitive to lexical perturbations.
 - Infer more constraints from co-located declarations.  Most of the stuff
-  in the runtime's residue could be disposed of this way.
-- Analyze the package's *_test.go files too.  If they define an external
-  test package, we'll have to deal with two packages at once.
-- Write tests.
+  in the runtime's residue could be disposed of this way.  -colocate
+  handles declaration groups; adjacent declarations are not yet used.
+- Refactor the package's *_test.go files too.  With -tests, they are
+  analyzed, but omitted from the output.
+- Write more tests.  The -outdir output has golden-file tests, in
+  testdata/golden, regenerated by "go test -run=Golden -update"; the
+  analysis and the reports have few.  (The -dump format is meant for
+  golden tests of the analysis.)
 
 */
 package main
@@ -3,14 +3,32 @@ package main
 // This file emits renderings of all three levels of graphs as SVG files.
 
 import (
+	"bytes"
 	"fmt"
+	"go/ast"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/arl/sockdrawer/analysis"
 )
 
-func renderGraphs(clusters []*cluster, scgraph map[*scnode]bool) error {
+func (o *organizer) renderGraphs(clusters []*analysis.Cluster, scgraph map[*analysis.SCNode]bool) error {
+	// The graph of clusters shows the exports each edge requires;
+	// see renamedForExport.
+	if *varAccessors {
+		if err := o.computeExports(clusters); err != nil {
+			return err
+		}
+	}
+
 	fmt.Fprintln(os.Stderr, "Rendering graphs")
 	if err := os.MkdirAll(*graphdir, 0755); err != nil {
 		return err
@@ -18,21 +36,180 @@ func renderGraphs(clusters []*cluster, scgraph map[*scnode]bool) error {
 
 	// Write the graph of clusters.
 	base := "clusters"
-	if err := writeClusters(base+".dot", clusters); err != nil {
+	roots := []string{base}
+	if err := o.writeClusters(base+".dot", clusters); err != nil {
 		return err
 	}
-	if err := runDot(base+".dot", base+".svg"); err != nil {
+	if err := runDot(base+".dot", renderedName(base)); err != nil {
 		return err
 	}
+
+	// Write the pre-expanded view?
+	if *expandLevel != "clusters" {
+		level := *expandLevel
+		if size := combinedSize(clusters, level); size > maxCombined {
+			fmt.Fprintf(os.Stderr, "warning: -expand-level=%s would draw %d boxes; "+
+				"falling back to -expand-level=scnodes\n", level, size)
+			level = "scnodes"
+		}
+		base = "combined"
+		if err := writeCombined(base+".dot", clusters, level); err != nil {
+			return err
+		}
+		if err := runDot(base+".dot", renderedName(base)); err != nil {
+			return err
+		}
+		roots = append(roots, base)
+	}
+
+	// Assemble a single page?
+	if *htmlView {
+		if err := writeHTML(roots, clusters); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "\nRun:\n\t%% browser %s\n",
+			filepath.Join(*graphdir, "sockdrawer.html"))
+		return nil
+	}
+
 	fmt.Fprintf(os.Stderr, "\nRun:\n\t%% browser %s\n",
-		filepath.Join(*graphdir, base+".svg"))
+		filepath.Join(*graphdir, renderedName(base)))
+
+	return nil
+}
+
+// maxCombined is the maximum number of boxes in the pre-expanded view.
+const maxCombined = 2000
+
+// combinedSize returns the number of boxes drawn by the pre-expanded
+// view at the specified level.
+func combinedSize(clusters []*analysis.Cluster, level string) int {
+	size := len(clusters)
+	for _, c := range clusters {
+		scnodes := make(map[*analysis.SCNode]bool)
+		for n := range c.Nodes {
+			scnodes[n.SCC] = true
+		}
+		size += len(scnodes)
+		if level == "nodes" {
+			size += len(c.Nodes)
+		}
+	}
+	return size
+}
+
+// writeCombined writes to dotfile a single graph in which each
+// cluster is drawn as a box containing its scnodes and, at the
+// "nodes" level, each plural scnode as a box containing its nodes.
+func writeCombined(dotfile string, clusters []*analysis.Cluster, level string) (err error) {
+	f, err := os.Create(filepath.Join(*graphdir, dotfile))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	fmt.Fprintln(f, "digraph combined {")
+	writeStyle(f)
+	fmt.Fprintln(f, `  edge [arrowhead="open"];`)
+	fmt.Fprintln(f, `  labelloc="t"; label="All clusters\n\n";`)
+	fmt.Fprintln(f, `  node [shape="box",style=filled,fillcolor="#f0e0ff"];`)
+	for _, c := range clusters {
+		fmt.Fprintf(f, "  subgraph cluster_c%d {\n", c.ID)
+		fmt.Fprintf(f, "    style=\"rounded,filled\"; fillcolor=\"#e0ffe0\"; label=%s;\n", dotQuote(c.ImportPath))
+		scnodes := make(map[*analysis.SCNode]bool)
+		for n := range c.Nodes {
+			scnodes[n.SCC] = true
+		}
+		for _, s := range analysis.SortedSCNodes(scnodes) {
+			if *hideIsolated && s.IsIsolated() {
+				continue
+			}
+			if level == "scnodes" || len(s.Nodes) == 1 {
+				url := renderedName(fmt.Sprintf("scc%d", s.ID))
+				if len(s.Nodes) == 1 {
+					url = godocURL(anyNode(s))
+				}
+				fmt.Fprintf(f, "    s%d [fillcolor=%s,%stooltip=%s,label=%s];\n",
+					s.ID, dotQuote(sccColor(s)), urlAttr(url), dotQuote(sccTooltip(s)), dotQuote(s.String()))
+				continue
+			}
+			fmt.Fprintf(f, "    subgraph cluster_s%d {\n", s.ID)
+			fmt.Fprintln(f, `      style=filled; fillcolor="#e0f0ff"; label="";`)
+			for _, n := range analysis.SortedNodes(s.Nodes) {
+				fmt.Fprintf(f, "      n%d [fillcolor=%s,%stooltip=%s,label=%s];\n",
+					n.ID, dotQuote(kindColors[n.Kind()]), urlAttr(godocURL(n)), dotQuote(nodeTooltip(n)), dotQuote(n.String()))
+			}
+			if *groupMethods {
+				writeMethodGroups(f, "      ", s.Nodes)
+			}
+			fmt.Fprintln(f, "    }")
+		}
+		fmt.Fprintln(f, "  }")
+	}
 
+	// edges
+	for _, c := range clusters {
+		for _, n := range analysis.SortedNodes(c.Nodes) {
+			if level == "scnodes" {
+				if n != anyNode(n.SCC) {
+					continue // visit each scnode once
+				}
+				for _, succ := range analysis.SortedSCNodes(n.SCC.Succs) {
+					fmt.Fprintf(f, "  s%d -> s%d;\n", n.SCC.ID, succ.ID)
+				}
+				continue
+			}
+			for _, succ := range analysis.SortedNodes(n.Succs) {
+				if attrs, ok := methodEdgeAttrs(n, succ); ok {
+					fmt.Fprintf(f, "  %s -> %s%s;\n", combinedID(n), combinedID(succ), attrs)
+				}
+			}
+		}
+	}
+	fmt.Fprintln(f, "}")
 	return nil
 }
 
+// combinedID returns the identifier of the box for n
+// in the "nodes" level of the pre-expanded view.
+func combinedID(n *analysis.Node) string {
+	if len(n.SCC.Nodes) == 1 {
+		return fmt.Sprintf("s%d", n.SCC.ID)
+	}
+	return fmt.Sprintf("n%d", n.ID)
+}
+
+// sccColor returns the fill color for scnode s.
+func sccColor(s *analysis.SCNode) string {
+	if len(s.Nodes) == 1 {
+		return "#f0e0ff"
+	}
+	return "#e0f0ff"
+}
+
+// anyNode returns an element of s: the first, in id order, so that
+// repeated calls agree.
+func anyNode(s *analysis.SCNode) *analysis.Node {
+	var first *analysis.Node
+	for n := range s.Nodes {
+		if first == nil || n.ID < first.ID {
+			first = n
+		}
+	}
+	return first
+}
+
 // writeClusters writes to dotfile the graph (DAG) of clusters.
 // It also generates all subgraphs.
-func writeClusters(dotfile string, clusters []*cluster) (err error) {
+//
+// Each edge is as thick as the number of node-graph edges it
+// represents warrants, and red if any of them requires an object to
+// be exported, gray otherwise.  It must be called after computeExports.
+func (o *organizer) writeClusters(dotfile string, clusters []*analysis.Cluster) (err error) {
 	f, err := os.Create(filepath.Join(*graphdir, dotfile))
 	if err != nil {
 		return err
@@ -44,42 +221,73 @@ func writeClusters(dotfile string, clusters []*cluster) (err error) {
 	}()
 
 	fmt.Fprintln(f, "digraph clusters {")
+	writeStyle(f)
 	fmt.Fprintln(f, `  node [shape="box",style="rounded,filled",fillcolor="#e0ffe0"];`)
 	fmt.Fprintln(f, `  edge [arrowhead="open"];`)
 	fmt.Fprintln(f, `  labelloc="t"; label="All clusters\n\n";`)
+
+	// Count the node edges of each cluster edge, noting those
+	// whose heads must be exported.
+	// (Tests are not refactored, so they require no exports.)
+	refs := make(map[[2]*analysis.Cluster]int)
+	exports := make(map[[2]*analysis.Cluster]bool)
+	maxRefs := 1
+	for _, n := range o.Nodes {
+		for succ := range n.Succs {
+			if succ.Cluster == n.Cluster {
+				continue
+			}
+			edge := [2]*analysis.Cluster{n.Cluster, succ.Cluster}
+			refs[edge]++
+			if refs[edge] > maxRefs {
+				maxRefs = refs[edge]
+			}
+			if !n.IsTest() && o.renamedForExport(succ) {
+				exports[edge] = true
+			}
+		}
+	}
+
 	for _, c := range clusters {
-		base := fmt.Sprintf("cluster%d", c.id)
+		base := fmt.Sprintf("cluster%d", c.ID)
 
 		// nodes
-		// NB: %q is not quite the graphviz quoting function.
-		fmt.Fprintf(f, "  n%d [URL=%q,label=%q];\n", c.id, base+".svg",
-			strings.Replace(c.importPath, "/", "/\n", -1))
+		fmt.Fprintf(f, "  n%d [URL=%s,tooltip=%s,label=%s];\n", c.ID, dotQuote(renderedName(base)),
+			dotQuote(fmt.Sprintf("%s\n%d nodes", c.ImportPath, len(c.Nodes))),
+			dotQuote(strings.Replace(c.ImportPath, "/", "/\n", -1)))
 
 		// Find scnodes of nodes of this cluster.
-		scnodes := make(map[*scnode]bool)
-		for n := range c.nodes {
-			scnodes[n.scc] = true
+		scnodes := make(map[*analysis.SCNode]bool)
+		for n := range c.Nodes {
+			scnodes[n.SCC] = true
 		}
 
 		// Project edges from SCC graph onto clusters.
-		succs := make(map[*cluster]bool)
+		succs := make(map[*analysis.Cluster]bool)
 		for s := range scnodes {
-			for succ := range s.succs {
-				if succ.cluster != c {
-					succs[succ.cluster] = true
+			for succ := range s.Succs {
+				if succ.Cluster != c {
+					succs[succ.Cluster] = true
 				}
 			}
 		}
 
 		// edges
-		for succ := range succs {
-			fmt.Fprintf(f, "  n%d -> n%d;\n", c.id, succ.id)
+		for _, succ := range analysis.SortedClusters(succs) {
+			edge := [2]*analysis.Cluster{c, succ}
+			color := "gray"
+			if exports[edge] {
+				color = "red"
+			}
+			width := 1 + 4*float64(refs[edge])/float64(maxRefs)
+			fmt.Fprintf(f, "  n%d -> n%d [color=%s,penwidth=%.1f,tooltip=%s];\n",
+				c.ID, succ.ID, dotQuote(color), width, dotQuote(fmt.Sprintf("node edges: %d", refs[edge])))
 		}
 
-		if err := writeSCCs(c.importPath, base+".dot", scnodes); err != nil {
+		if err := writeSCCs(c.ImportPath, base+".dot", scnodes); err != nil {
 			return err
 		}
-		if err := runDot(base+".dot", base+".svg"); err != nil {
+		if err := runDot(base+".dot", renderedName(base)); err != nil {
 			return err
 		}
 	}
@@ -87,9 +295,22 @@ func writeClusters(dotfile string, clusters []*cluster) (err error) {
 	return nil
 }
 
+// renamedForExport reports whether any object declared by n must be
+// renamed so that other clusters can refer to it: whether any is
+// unexported, save variables with accessors (-var-accessors), which
+// computeExports must have planned.
+func (o *organizer) renamedForExport(n *analysis.Node) bool {
+	for i, name := range n.Names() {
+		if !ast.IsExported(name) && (o.accessors == nil || o.accessors[n.Objects[i]] == nil) {
+			return true
+		}
+	}
+	return false
+}
+
 // writeSCCs writes to dotfile the graph (DAG) of SCCs for a single cluster.
 // It also generates all subgraphs.
-func writeSCCs(name, dotfile string, scgraph map[*scnode]bool) (err error) {
+func writeSCCs(name, dotfile string, scgraph map[*analysis.SCNode]bool) (err error) {
 	f, err := os.Create(filepath.Join(*graphdir, dotfile))
 	if err != nil {
 		return err
@@ -101,50 +322,62 @@ func writeSCCs(name, dotfile string, scgraph map[*scnode]bool) (err error) {
 	}()
 
 	fmt.Fprintln(f, "digraph scgraph {")
+	writeStyle(f)
 	fmt.Fprintln(f, `  graph [rankdir=LR];`)
 	fmt.Fprintln(f, `  edge [arrowhead="open"];`)
-	fmt.Fprintf(f, `  labelloc="t"; label="Cluster: %s\n\n";`, name)
+	fmt.Fprintf(f, "  labelloc=\"t\"; label=%s;", dotQuote("Cluster: "+name+"\n\n"))
 	fmt.Fprintln(f, `  node [shape="box",style=filled];`)
-	for s := range scgraph {
+	stubs := make(map[*analysis.Cluster]bool) // other clusters depended upon
+	for _, s := range analysis.SortedSCNodes(scgraph) {
+		if *hideIsolated && s.IsIsolated() {
+			continue
+		}
+
 		// nodes
 		var url, color string
-		if len(s.nodes) == 1 {
-			for n := range s.nodes {
-				url = n.godocURL()
-			}
+		if len(s.Nodes) == 1 {
+			url = godocURL(anyNode(s))
 			color = "#f0e0ff"
 		} else {
-			base := fmt.Sprintf("scc%d", s.id)
-			if err := writeNodes(base+".dot", s.String(), s.nodes); err != nil {
+			base := fmt.Sprintf("scc%d", s.ID)
+			if err := writeNodes(base+".dot", s.String(), s.Nodes); err != nil {
 				return err
 			}
-			if err := runDot(base+".dot", base+".svg"); err != nil {
+			if err := runDot(base+".dot", renderedName(base)); err != nil {
 				return err
 			}
 
-			url = base + ".svg"
+			url = renderedName(base)
 			color = "#e0f0ff"
 		}
-		// NB: %q is not quite the graphviz quoting function.
-		fmt.Fprintf(f, "  n%d [fillcolor=%q,URL=%q,label=%q];\n", s.id, color, url, s.String())
+		fmt.Fprintf(f, "  n%d [fillcolor=%s,%stooltip=%s,label=%s];\n",
+			s.ID, dotQuote(color), urlAttr(url), dotQuote(sccTooltip(s)), dotQuote(s.String()))
 
-		// intra-cluster edges
-		for succ := range s.succs {
-			if succ.cluster == s.cluster {
-				fmt.Fprintf(f, "  n%d -> n%d;\n", s.id, succ.id)
-			} else {
-				// TODO(adonovan): show inter-cluster edges?
-				// Probably too much.
+		// Intra-cluster edges are solid; inter-cluster edges
+		// are dashed, and lead to a stub for the other cluster.
+		targets := make(map[*analysis.Cluster]bool)
+		for _, succ := range analysis.SortedSCNodes(s.Succs) {
+			if succ.Cluster == s.Cluster {
+				fmt.Fprintf(f, "  n%d -> n%d;\n", s.ID, succ.ID)
+			} else if !targets[succ.Cluster] {
+				targets[succ.Cluster] = true
+				stubs[succ.Cluster] = true
+				fmt.Fprintf(f, "  n%d -> c%d [style=dashed];\n", s.ID, succ.Cluster.ID)
 			}
 		}
 	}
+	for _, c := range analysis.SortedClusters(stubs) {
+		fmt.Fprintf(f, "  c%d [shape=box,style=\"rounded,dashed\",URL=%s,tooltip=%s,label=%s];\n",
+			c.ID, dotQuote(renderedName(fmt.Sprintf("cluster%d", c.ID))),
+			dotQuote(fmt.Sprintf("%s\n%d nodes", c.ImportPath, len(c.Nodes))), dotQuote(c.ImportPath))
+	}
 	fmt.Fprintln(f, "}")
 	return nil
 }
 
 // writeNodes writes to dotfile the graph (strongly connected) of nodes
 // (package-level named entities) for a single non-trivial SCC.
-func writeNodes(dotfile, name string, graph map[*node]bool) (err error) {
+func writeNodes(dotfile, name string, graph map[*analysis.Node]bool) (err error) {
 	f, err := os.Create(filepath.Join(*graphdir, dotfile))
 	if err != nil {
 		return err
@@ -159,31 +392,344 @@ func writeNodes(dotfile, name string, graph map[*node]bool) (err error) {
 	// equivalent topology (same set of succs/preds).
 
 	fmt.Fprintln(f, "digraph scgraph {")
+	writeStyle(f)
+
+	// Too large to render usefully (or quickly)?
+	// Show a placeholder linking to a list of the nodes.
+	if *maxNodes > 0 && len(graph) > *maxNodes {
+		listing := strings.TrimSuffix(dotfile, ".dot") + ".txt"
+		var buf bytes.Buffer
+		for _, n := range analysis.SortedNodes(graph) {
+			fmt.Fprintln(&buf, n)
+		}
+		if err := ioutil.WriteFile(filepath.Join(*graphdir, listing), buf.Bytes(), 0666); err != nil {
+			return err
+		}
+		fmt.Fprintf(f, "  n0 [shape=box,URL=%s,label=%s];\n", dotQuote(listing),
+			dotQuote(fmt.Sprintf("SCC too large: %d nodes; split further", len(graph))))
+		fmt.Fprintln(f, "}")
+		return nil
+	}
+
 	fmt.Fprintln(f, `  edge [arrowhead="open"];`)
-	fmt.Fprintf(f, `  labelloc="t"; label="Strongly connected component: %s\n\n";`, name)
-	fmt.Fprintln(f, `  node [shape="box",style=filled,fillcolor="#f0e0ff"];`)
+	fmt.Fprintf(f, "  labelloc=\"t\"; label=%s;", dotQuote("Strongly connected component: "+name+"\n\n"))
+	fmt.Fprintln(f, `  node [shape="box",style=filled];`)
 
-	for n := range graph {
-		// nodes
-		// NB: %q is not quite the graphviz quoting function.
-		fmt.Fprintf(f, "  n%d [URL=%q,label=%q];\n", n.id, n.godocURL(), n.String())
+	for _, n := range analysis.SortedNodes(graph) {
+		if *hideIsolated && n.IsIsolated() {
+			continue // possible only with -fuse
+		}
+
+		// nodes, colored by kind
+		fmt.Fprintf(f, "  n%d [fillcolor=%s,%stooltip=%s,label=%s];\n",
+			n.ID, dotQuote(kindColors[n.Kind()]), urlAttr(godocURL(n)), dotQuote(nodeTooltip(n)), dotQuote(n.String()))
 
 		// TODO(adonovan): display two edges a-->b and b-->a as
 		// a single double-headed one.
 
-		// SCC-internal edges (ignoring synthetic edges from annotations)
-		for succ, real := range n.succs {
-			if real && succ.scc.id == n.scc.id {
-				fmt.Fprintf(f, "  n%d -> n%d;\n", n.id, succ.id)
+		// SCC-internal edges
+		for _, succ := range analysis.SortedNodes(n.Succs) {
+			if succ.SCC.ID == n.SCC.ID {
+				if attrs, ok := methodEdgeAttrs(n, succ); ok {
+					fmt.Fprintf(f, "  n%d -> n%d%s;\n", n.ID, succ.ID, attrs)
+				}
 			}
 		}
 	}
+	if *groupMethods {
+		writeMethodGroups(f, "  ", graph)
+	}
 	fmt.Fprintln(f, "}")
 	return nil
 }
 
-func runDot(dotfile, svgfile string) error {
-	cmd := exec.Command("/bin/sh", "-c", "/usr/bin/dot -Tsvg "+filepath.Join(*graphdir, dotfile)+" >"+filepath.Join(*graphdir, svgfile))
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// writeMethodGroups writes, for -group-methods, a dashed box around
+// each type node of graph and those of its concrete methods that are
+// in graph, at the specified indentation.  The boxes refer to node
+// boxes n%d already declared.
+func writeMethodGroups(w io.Writer, indent string, graph map[*analysis.Node]bool) {
+	methods := make(map[*analysis.Node][]*analysis.Node) // by receiver type
+	for _, n := range analysis.SortedNodes(graph) {
+		if t := n.RecvNode(); t != nil && graph[t] {
+			methods[t] = append(methods[t], n)
+		}
+	}
+	for _, t := range analysis.SortedNodes(graph) {
+		if len(methods[t]) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%ssubgraph cluster_t%d {\n", indent, t.ID)
+		fmt.Fprintf(w, "%s  style=\"rounded,dashed\"; label=\"\";\n", indent)
+		fmt.Fprintf(w, "%s  n%d;", indent, t.ID)
+		for _, m := range methods[t] {
+			fmt.Fprintf(w, " n%d;", m.ID)
+		}
+		fmt.Fprintf(w, "\n%s}\n", indent)
+	}
+}
+
+// methodEdgeAttrs reports whether the node-graph edge from -> to
+// should be drawn under -show-method-edges, and if so, with what
+// additional dot attributes.
+func methodEdgeAttrs(from, to *analysis.Node) (string, bool) {
+	if *methodEdges == "all" {
+		return "", true
+	}
+	switch {
+	case isMethodEdge(from, to):
+		// synthetic edge from receiver type to method
+		if *methodEdges != "both" {
+			return "", false
+		}
+		if to.Succs[from] {
+			return "", false // drawn double-headed from the method
+		}
+	case isMethodEdge(to, from):
+		// real edge from method to its receiver type
+		switch *methodEdges {
+		case "none":
+			return "", false
+		case "both":
+			return ` [dir="both"]`, true
+		}
+	}
+	return "", true
+}
+
+// kindColors holds the fill colors of the nodes of node graphs, by
+// kind of declaration (see node.kind); -kind-colors overrides them.
+var kindColors = map[string]string{
+	"func":   "#f0e0ff",
+	"method": "#dcc8ff",
+	"type":   "#ffe4c4",
+	"var":    "#fff8b0",
+	"const":  "#d4f4d4",
+}
+
+// parseKindColors applies the value of -kind-colors, a
+// comma-separated list of kind=color pairs, to kindColors.
+func parseKindColors(list string) error {
+	for _, pair := range strings.Split(list, ",") {
+		eq := strings.Index(pair, "=")
+		if eq < 0 {
+			return fmt.Errorf("invalid -kind-colors entry %q; want kind=color", pair)
+		}
+		kind, color := strings.TrimSpace(pair[:eq]), strings.TrimSpace(pair[eq+1:])
+		if _, ok := kindColors[kind]; !ok || color == "" {
+			return fmt.Errorf("invalid -kind-colors entry %q; want kind=color, kind one of func, method, type, var, const", pair)
+		}
+		kindColors[kind] = color
+	}
+	return nil
+}
+
+// parseFont parses the value of -font, "family[:size]".
+// A size of zero means the graphviz default.
+func parseFont(spec string) (family string, size float64, err error) {
+	family = spec
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		family = spec[:i]
+		size, err = strconv.ParseFloat(spec[i+1:], 64)
+		if err != nil || size <= 0 {
+			return "", 0, fmt.Errorf("invalid -font size: %q", spec[i+1:])
+		}
+	}
+	return family, size, nil
+}
+
+// urlAttr returns the URL attribute, with a trailing comma, of a node
+// that links to url, or "" for a node that links nowhere.
+func urlAttr(url string) string {
+	if url == "" {
+		return ""
+	}
+	return "URL=" + dotQuote(url) + ","
+}
+
+// dotQuote returns s as a graphviz double-quoted string.  Quotation
+// marks and backslashes are escaped, and newlines become \n escapes,
+// which graphviz renders as centered line breaks; other characters,
+// non-ASCII ones included, stand for themselves.
+func dotQuote(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case '\n':
+			buf.WriteString(`\n`)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// writeStyle writes the attributes for the font and resolution
+// of a rendered graph (-font, -dpi), if any.
+func writeStyle(w io.Writer) {
+	var attrs []string
+	family, size, _ := parseFont(*font) // validated by doMain
+	if family != "" {
+		attrs = append(attrs, "fontname="+dotQuote(family))
+	}
+	if size > 0 {
+		attrs = append(attrs, fmt.Sprintf("fontsize=%g", size))
+	}
+	if len(attrs) > 0 {
+		list := strings.Join(attrs, ",")
+		fmt.Fprintf(w, "  node [%s];\n", list)
+		fmt.Fprintf(w, "  edge [%s];\n", list)
+		if *dpi > 0 {
+			list += fmt.Sprintf(",dpi=%d", *dpi)
+		}
+		fmt.Fprintf(w, "  graph [%s];\n", list)
+	} else if *dpi > 0 {
+		fmt.Fprintf(w, "  graph [dpi=%d];\n", *dpi)
+	}
+}
+
+// renderedName returns the name of the file to which the graph
+// base.dot is rendered, in the format specified by -graph-format.
+func renderedName(base string) string {
+	return base + "." + *graphFormat
+}
+
+// runDot renders dotfile to outfile using the graphviz dot command
+// (-dot, or "dot" on the PATH) in the format given by -graph-format.
+func runDot(dotfile, outfile string) (err error) {
+	dotPath := *dotCmd
+	if dotPath == "" {
+		if dotPath, err = exec.LookPath("dot"); err != nil {
+			return fmt.Errorf("can't find graphviz dot command (use -dot=path): %v", err)
+		}
+	}
+	out, err := os.Create(filepath.Join(*graphdir, outfile))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	cmd := exec.Command(dotPath, "-T"+*graphFormat, filepath.Join(*graphdir, dotfile))
+	cmd.Stdout = out
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// The .dot file is left in place for inspection.
+		msg := strings.TrimSpace(stderr.String())
+		if line := dotErrorLine(dotfile, msg); line != "" {
+			msg += "\n\toffending line: " + line
+		}
+		return fmt.Errorf("dot failed on %s: %v\n%s",
+			filepath.Join(*graphdir, dotfile), err, msg)
+	}
+	return nil
+}
+
+var dotLineRE = regexp.MustCompile(`line (\d+)`)
+
+// dotErrorLine returns the line of dotfile mentioned by dot's
+// error message msg, or "" if none.
+func dotErrorLine(dotfile, msg string) string {
+	m := dotLineRE.FindStringSubmatch(msg)
+	if m == nil {
+		return ""
+	}
+	linenum, _ := strconv.Atoi(m[1])
+	data, err := ioutil.ReadFile(filepath.Join(*graphdir, dotfile))
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(data), "\n")
+	if linenum < 1 || linenum > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[linenum-1])
+}
+
+// nodeTooltip returns the text displayed when hovering over n in a
+// rendered graph: its qualified name, position and exportedness.
+func nodeTooltip(n *analysis.Node) string {
+	posn := n.Graph.Fset.Position(n.Pos())
+	exported := "unexported"
+	if n.Exportedness() > 0 {
+		exported = "exported"
+	}
+	if n.IsTest() {
+		exported += ", test only"
+	}
+	return fmt.Sprintf("%s.%s\n%s:%d\n%s",
+		n.Graph.Info.Pkg.Path(), n.Name, filepath.Base(posn.Filename), posn.Line, exported)
+}
+
+// sccTooltip returns the text displayed when hovering over s in a
+// rendered graph: the tooltip of its sole node, or the names
+// of all its nodes.
+func sccTooltip(s *analysis.SCNode) string {
+	if len(s.Nodes) == 1 {
+		for n := range s.Nodes {
+			return nodeTooltip(n)
+		}
+	}
+	names := make([]string, 0, len(s.Nodes))
+	for n := range s.Nodes {
+		names = append(names, n.Name)
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("%d nodes:\n%s", len(names), strings.Join(names, "\n"))
+}
+
+// godocURL returns the URL of n in the -godoc server,
+// or "" if there is none (-godoc=none).
+func godocURL(n *analysis.Node) string {
+	if *godoc == "" || *godoc == "none" {
+		return ""
+	}
+	if *godocStyle == "pkgsite" {
+		return pkgsiteURL(n)
+	}
+
+	// The godoc server lays out sources by import path, wherever
+	// they lie on disk, e.g. in the module cache.
+	posn := n.Graph.Fset.Position(n.Pos())
+	file := path.Join("src", n.Graph.Info.Pkg.Path(), filepath.Base(posn.Filename))
+
+	selLen := 1
+	switch kind := n.Kind(); {
+	case n.IsGrouped():
+		// For "var (...; x, y = ...)", select "x, y",
+		// and for "type (...; x T; ...)", "x".
+		selLen = len(strings.Join(n.Names(), ", "))
+	case kind == "method":
+		selLen = len("func")
+	case kind != "":
+		selLen = len(kind) // func, const, var or type
+	}
+	return fmt.Sprintf("%s/%s?s=%d:%d#L%d", *godoc,
+		file, posn.Offset, posn.Offset+selLen, posn.Line)
+}
+
+// pkgsiteURL returns the URL of the documentation of n in a pkgsite
+// server, whose anchors are symbol names, e.g. #T.Method.  Nodes
+// declaring no object, such as init functions, link to the package.
+func pkgsiteURL(n *analysis.Node) string {
+	url := *godoc + "/" + n.Graph.Info.Pkg.Path()
+	if names := n.Names(); names != nil {
+		anchor := names[0]
+		if recv := strings.TrimPrefix(n.RecvType(), "*"); recv != "" {
+			// The anchor of a method of List[T] is List.Method.
+			if i := strings.Index(recv, "["); i >= 0 {
+				recv = recv[:i]
+			}
+			anchor = recv + "." + anchor
+		}
+		url += "#" + anchor
+	}
+	return url
 }
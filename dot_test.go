@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/arl/sockdrawer/internal/analysistest"
+)
+
+func TestDotQuote(t *testing.T) {
+	for _, test := range []struct{ s, want string }{
+		{``, `""`},
+		{`plain`, `"plain"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\dir`, `"C:\\dir"`},
+		{`\"`, `"\\\""`},
+		{`\n`, `"\\n"`}, // a backslash and n, not a line break
+		{"two\nlines", `"two\nlines"`},
+		{`{}`, `"{}"`},
+		{"héllo", `"héllo"`},
+	} {
+		if got := dotQuote(test.s); got != test.want {
+			t.Errorf("dotQuote(%q) = %s, want %s", test.s, got, test.want)
+		}
+	}
+}
+
+// The godoc URL of a node is derived from its import path, even if
+// its file lies outside any GOPATH src directory, as in the module
+// cache; it selects the keyword of a declaration, or the names of a
+// spec of a group.  The pkgsite URL of a method is anchored at the
+// name of its receiver type.
+func TestGodocURL(t *testing.T) {
+	setFlag(t, "godoc", "http://localhost:6060")
+	o := loadSource(t, map[string]string{"p.go": `package p
+
+func F() {}
+
+var (
+	x, y = 1, 2
+)
+
+type List[T any] struct{}
+
+func (*List[T]) Len() int { return 0 }
+`})
+	for _, test := range []struct{ style, name, want string }{
+		{"classic", "F", "http://localhost:6060/src/p/p.go?s=11:15#L3"},
+		{"classic", "x", "http://localhost:6060/src/p/p.go?s=31:35#L6"},
+		{"classic", "List", "http://localhost:6060/src/p/p.go?s=46:50#L9"},
+		{"pkgsite", "(*List[T]).Len", "http://localhost:6060/p#List.Len"},
+		{"pkgsite", "x", "http://localhost:6060/p#x"},
+	} {
+		setFlag(t, "godoc-style", test.style)
+		if got := godocURL(analysistest.LookupNode(t, o.Graph, test.name)); got != test.want {
+			t.Errorf("godocURL(%s), -godoc-style=%s = %q, want %q", test.name, test.style, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+// This file defines -dump, which prints an intermediate
+// representation of the analysis in a stable, line-oriented text
+// format suitable for golden tests and diffs.
+//
+// Each line consists of tab-separated fields.  Nodes are identified
+// by their names, as used in the clusters file, and all lists are in
+// the lexical order of the nodes' declarations.
+//
+//	-dump=nodes	one line "node NAME" per node, followed by one line
+//			"edge FROM TO" per edge of the node graph.
+//	-dump=sccs	one line "scc I NAME..." per strongly connected
+//			component, numbered in order of their first node.
+//	-dump=clusters	one line "cluster PATH NAME..." per cluster, in
+//			topological order, lowest first; the residue is last.
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// dump writes the specified intermediate representation to w.
+func (o *organizer) dump(w io.Writer, what string, clusters []*analysis.Cluster) error {
+	switch what {
+	case "nodes":
+		for _, n := range o.Nodes {
+			fmt.Fprintf(w, "node\t%s\n", n.Name)
+		}
+		for _, n := range o.Nodes {
+			for _, succ := range analysis.SortedNodes(n.Succs) {
+				fmt.Fprintf(w, "edge\t%s\t%s\n", n.Name, succ.Name)
+			}
+		}
+
+	case "sccs":
+		var sccs [][]*analysis.Node
+		for s := range o.SCGraph(false) {
+			sccs = append(sccs, analysis.SortedNodes(s.Nodes))
+		}
+		sort.Slice(sccs, func(i, j int) bool { return sccs[i][0].ID < sccs[j][0].ID })
+		for i, members := range sccs {
+			fmt.Fprintf(w, "scc\t%d\t%s\n", i, nodeNames(members))
+		}
+
+	case "clusters":
+		for _, c := range clusters {
+			fmt.Fprintf(w, "cluster\t%s\t%s\n", c.ImportPath, nodeNames(analysis.SortedNodes(c.Nodes)))
+		}
+
+	default:
+		return fmt.Errorf("unknown -dump: %q", what)
+	}
+	return nil
+}
+
+// nodeNames returns the tab-separated names of the nodes.
+func nodeNames(nodes []*analysis.Node) string {
+	names := make([]string, len(nodes))
+	for i, n := range nodes {
+		names[i] = n.Name
+	}
+	return strings.Join(names, "\t")
+}
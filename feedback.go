@@ -0,0 +1,199 @@
+package main
+
+// This file computes approximate minimum feedback arc sets: small
+// sets of node-graph edges whose removal makes the graph acyclic.
+
+import (
+	"fmt"
+	"go/token"
+	"sort"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// feedbackEdges returns a small set of edges among the specified nodes
+// whose removal makes the subgraph they induce acyclic, using the
+// greedy heuristic of Eades, Lin and Smyth.  The synthetic edges from
+// receiver types to their methods are never cut, so a type and its
+// methods are treated as a unit, and only edges between units are cut.
+func feedbackEdges(nodes []*analysis.Node) []analysis.Edge {
+	in := make(map[*analysis.Node]bool)
+	for _, n := range nodes {
+		in[n] = true
+	}
+	// unit maps each node to its unit: its receiver type, if any
+	// among nodes, otherwise itself.
+	unit := make(map[*analysis.Node]*analysis.Node)
+	members := make(map[*analysis.Node][]*analysis.Node)
+	for _, n := range analysis.SortedNodes(in) {
+		u := n
+		if t := n.RecvNode(); t != nil && in[t] {
+			u = t
+		}
+		unit[n] = u
+		members[u] = append(members[u], n)
+	}
+	units := make([]*analysis.Node, 0, len(members))
+	for u := range members {
+		units = append(units, u)
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].ID < units[j].ID })
+
+	placed := make(map[*analysis.Node]bool)
+	succs := func(u *analysis.Node) []*analysis.Node {
+		var res []*analysis.Node
+		seen := make(map[*analysis.Node]bool)
+		for _, n := range members[u] {
+			for s := range n.Succs {
+				if v := unit[s]; in[s] && v != u && !placed[v] && !seen[v] {
+					seen[v] = true
+					res = append(res, v)
+				}
+			}
+		}
+		return res
+	}
+	preds := func(u *analysis.Node) []*analysis.Node {
+		var res []*analysis.Node
+		seen := make(map[*analysis.Node]bool)
+		for _, n := range members[u] {
+			for p := range n.Preds {
+				if v := unit[p]; in[p] && v != u && !placed[v] && !seen[v] {
+					seen[v] = true
+					res = append(res, v)
+				}
+			}
+		}
+		return res
+	}
+
+	// Compute a sequence of units that has few backward edges:
+	// sinks go at the end, sources at the start, and otherwise
+	// the unit with the greatest surplus of outdegree.
+	indeg := make(map[*analysis.Node]int)
+	outdeg := make(map[*analysis.Node]int)
+	for _, u := range units {
+		indeg[u] = len(preds(u))
+		outdeg[u] = len(succs(u))
+	}
+	place := func(u *analysis.Node) {
+		placed[u] = true
+		for _, s := range succs(u) {
+			indeg[s]--
+		}
+		for _, p := range preds(u) {
+			outdeg[p]--
+		}
+	}
+	var head, tail []*analysis.Node
+	for len(head)+len(tail) < len(units) {
+		for changed := true; changed; {
+			changed = false
+			for _, u := range units {
+				if placed[u] {
+					continue
+				}
+				if outdeg[u] == 0 {
+					tail = append(tail, u)
+				} else if indeg[u] == 0 {
+					head = append(head, u)
+				} else {
+					continue
+				}
+				place(u)
+				changed = true
+			}
+		}
+		var best *analysis.Node
+		for _, u := range units {
+			if !placed[u] && (best == nil || outdeg[u]-indeg[u] > outdeg[best]-indeg[best]) {
+				best = u
+			}
+		}
+		if best != nil {
+			head = append(head, best)
+			place(best)
+		}
+	}
+
+	// The feedback edges are those pointing backwards.
+	order := make(map[*analysis.Node]int)
+	for i, u := range head {
+		order[u] = i
+	}
+	for i, u := range tail {
+		order[u] = len(units) - 1 - i
+	}
+	var result []analysis.Edge
+	for _, n := range nodes {
+		for s := range n.Succs {
+			if in[s] && order[unit[s]] < order[unit[n]] {
+				result = append(result, analysis.Edge{From: n, To: s})
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if x, y := result[i].From.ID, result[j].From.ID; x != y {
+			return x < y
+		}
+		return result[i].To.ID < result[j].To.ID
+	})
+	return result
+}
+
+// printSCCCuts prints a small set of node-graph edges whose removal
+// would make the SCC containing the named node acyclic, as
+// candidates for decoupling.
+func (o *organizer) printSCCCuts(name string) error {
+	var target *analysis.Node
+	for _, n := range o.Nodes {
+		if n.Name == name {
+			target = n
+			break
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("-scc-cuts: no node named %q", name)
+	}
+	o.SCGraph(false) // sets target.SCC
+	edges := feedbackEdges(analysis.SortedNodes(target.SCC.Nodes))
+	fmt.Printf("# Cutting these %d edges would make the SCC of %s (%d nodes) acyclic\n",
+		len(edges), name, len(target.SCC.Nodes))
+	for _, e := range edges {
+		fmt.Printf("%s: consider decoupling %s -> %s\n", o.Fset.Position(o.refPos(e)), e.From.Name, e.To.Name)
+	}
+	fmt.Println()
+	return nil
+}
+
+// isMethodEdge reports whether from -> to is the synthetic edge
+// from a receiver type to one of its concrete methods.
+func isMethodEdge(from, to *analysis.Node) bool {
+	t := to.RecvNode()
+	return t != nil && from == t
+}
+
+// printFeedbackEdges prints a set of node-graph edges whose removal
+// would make the node graph acyclic, with the positions of the
+// references that form them.
+func (o *organizer) printFeedbackEdges(nodes []*analysis.Node) {
+	edges := feedbackEdges(nodes)
+	fmt.Printf("# Cutting these %d edges would make the node graph acyclic\n", len(edges))
+	for _, e := range edges {
+		fmt.Printf("%s: %s -> %s\n", o.Fset.Position(o.refPos(e)), e.From.Name, e.To.Name)
+	}
+	fmt.Println()
+}
+
+// refPos returns the position of the first reference that forms the
+// edge e, or of e.from itself if there is none.  For a synthetic
+// edge between hot nodes, it is that of the reverse edge.
+func (o *organizer) refPos(e analysis.Edge) token.Pos {
+	if o.HotEdges[e] {
+		e = analysis.Edge{From: e.To, To: e.From} // synthetic; see BindHotNodes
+	}
+	if refs := e.From.Refs(e.To); refs != nil {
+		return refs[0]
+	}
+	return e.From.Pos()
+}
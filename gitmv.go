@@ -0,0 +1,98 @@
+package main
+
+// This file defines -gitmv, which writes a script of 'git mv'
+// commands so that git records each source file as renamed to the
+// output file that derives predominantly from it, preserving its
+// history, rather than as deleted while new files are added.
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// writeGitMv writes the script gitmv.sh to the output directory.
+// For each source file, at least half of whose declarations (by
+// size) go to a single cluster, the script moves the source file over
+// that cluster's output file derived from it, then restores the
+// output, leaving an edit to the moved file for git to record.
+// With -coalesce, several source files may share an output file;
+// only the first is moved.
+func (o *organizer) writeGitMv(clusters []*analysis.Cluster) error {
+	syntax := make(map[string]*ast.File) // source file -> syntax
+	for _, f := range o.Info.Files {
+		syntax[o.Fset.Position(f.Pos()).Filename] = f
+	}
+	sizes := make(map[string]map[*analysis.Cluster]int) // source file -> cluster -> bytes
+	var files []string
+	for _, n := range o.Nodes {
+		if n.IsTest() {
+			continue // tests are not refactored
+		}
+		filename := o.Fset.Position(n.Syntax.Pos()).Filename
+		if sizes[filename] == nil {
+			sizes[filename] = make(map[*analysis.Cluster]int)
+			files = append(files, filename)
+		}
+		sizes[filename][n.Cluster] += int(n.Syntax.End() - n.Syntax.Pos())
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("#!/bin/sh\n")
+	buf.WriteString("# Generated by sockdrawer -gitmv.  Run from the source repository,\n")
+	buf.WriteString("# after writing the output, to record each source file as renamed\n")
+	buf.WriteString("# to the output file derived predominantly from it.\n")
+	buf.WriteString("set -e\n")
+	moved := make(map[string]string) // output file -> source file
+	var nmoves int
+	for _, filename := range files {
+		var total, best int
+		var dominant *analysis.Cluster
+		for _, c := range clusters { // (in order, for determinism)
+			size := sizes[filename][c]
+			total += size
+			if size > best {
+				best, dominant = size, c
+			}
+		}
+		if best*2 < total {
+			fmt.Fprintf(os.Stderr, "%s: note: no cluster holds most of this file; not moving it\n", filename)
+			continue
+		}
+		base := o.grouping(dominant, syntax[filename], filename)
+		dst, err := filepath.Abs(filepath.Join(*outdir, dominant.ImportPath, base))
+		if err != nil {
+			return err
+		}
+		if prev, ok := moved[dst]; ok {
+			fmt.Fprintf(os.Stderr, "%s: note: %s was moved to %s already; not moving this file\n",
+				filename, filepath.Base(prev), base)
+			continue
+		}
+		moved[dst] = filename
+		tmp := dst + ".sockdrawer"
+		fmt.Fprintf(&buf, "\n# %s: %d%% to %s\n", filepath.Base(filename), best*100/total, dominant.ImportPath)
+		fmt.Fprintf(&buf, "mv -f %s %s\n", shellQuote(dst), shellQuote(tmp))
+		fmt.Fprintf(&buf, "git mv %s %s\n", shellQuote(filename), shellQuote(dst))
+		fmt.Fprintf(&buf, "mv -f %s %s\n", shellQuote(tmp), shellQuote(dst))
+		nmoves++
+	}
+
+	script := filepath.Join(*outdir, "gitmv.sh")
+	if err := ioutil.WriteFile(script, buf.Bytes(), 0777); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s (%d moves); run it, then 'git add' the output.\n", script, nmoves)
+	return nil
+}
+
+// shellQuote quotes s as a single word for the POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
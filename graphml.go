@@ -0,0 +1,64 @@
+package main
+
+// This file emits the cluster graph in GraphML format,
+// for use by diagramming tools such as yEd.
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// writeGraphML writes the cluster DAG to w in GraphML format.
+// Each cluster has attributes for its import path and for its number
+// of nodes and of exported nodes; each edge is weighted by the number
+// of node-graph edges it represents.
+func writeGraphML(w io.Writer, clusters []*analysis.Cluster) {
+	fmt.Fprintln(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(w, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(w, `  <key id="path" for="node" attr.name="importPath" attr.type="string"/>`)
+	fmt.Fprintln(w, `  <key id="nodes" for="node" attr.name="nodes" attr.type="int"/>`)
+	fmt.Fprintln(w, `  <key id="exported" for="node" attr.name="exported" attr.type="int"/>`)
+	fmt.Fprintln(w, `  <key id="weight" for="edge" attr.name="weight" attr.type="int"/>`)
+	fmt.Fprintln(w, `  <graph id="clusters" edgedefault="directed">`)
+	for _, c := range clusters {
+		var exported int
+		for n := range c.Nodes {
+			exported += n.Exportedness()
+		}
+		fmt.Fprintf(w, "    <node id=\"c%d\">\n", c.ID)
+		fmt.Fprintf(w, "      <data key=\"path\">%s</data>\n", xmlEscape(c.ImportPath))
+		fmt.Fprintf(w, "      <data key=\"nodes\">%d</data>\n", len(c.Nodes))
+		fmt.Fprintf(w, "      <data key=\"exported\">%d</data>\n", exported)
+		fmt.Fprintln(w, "    </node>")
+	}
+	for _, c := range clusters {
+		weights := make(map[*analysis.Cluster]int)
+		for _, n := range analysis.SortedNodes(c.Nodes) {
+			for _, succ := range analysis.SortedNodes(n.Succs) {
+				if succ.Cluster != c {
+					weights[succ.Cluster]++
+				}
+			}
+		}
+		for _, succ := range clusters {
+			if weight := weights[succ]; weight > 0 {
+				fmt.Fprintf(w, "    <edge source=\"c%d\" target=\"c%d\">\n", c.ID, succ.ID)
+				fmt.Fprintf(w, "      <data key=\"weight\">%d</data>\n", weight)
+				fmt.Fprintln(w, "    </edge>")
+			}
+		}
+	}
+	fmt.Fprintln(w, "  </graph>")
+	fmt.Fprintln(w, "</graphml>")
+}
+
+// xmlEscape returns s escaped for use as XML character data.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
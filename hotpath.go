@@ -0,0 +1,32 @@
+package main
+
+// This file defines performance-aware constraints (-hotpath): the
+// edges among performance-critical nodes should not cross package
+// boundaries, since calls between packages may inhibit inlining.
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// checkHotEdges reports each edge between hot nodes that crosses
+// a cluster boundary, and returns the number of such edges.
+func (o *organizer) checkHotEdges(hot map[*analysis.Node]bool) int {
+	var crossings int
+	for _, n := range o.Nodes {
+		if !hot[n] {
+			continue
+		}
+		for _, succ := range analysis.SortedNodes(n.Succs) {
+			if hot[succ] && succ.Cluster != n.Cluster && !isMethodEdge(n, succ) && !o.HotEdges[analysis.Edge{From: n, To: succ}] {
+				crossings++
+				fmt.Fprintf(os.Stderr, "%s: warning: hot-path edge %s -> %s crosses from %s to %s\n",
+					o.Fset.Position(o.refPos(analysis.Edge{From: n, To: succ})), n.Name, succ.Name,
+					n.Cluster.ImportPath, succ.Cluster.ImportPath)
+			}
+		}
+	}
+	return crossings
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arl/sockdrawer/analysis"
+	"github.com/arl/sockdrawer/internal/analysistest"
+)
+
+// The reverse edges added between hot nodes are not references, so
+// a crossing is reported once, at the reference that forms it.
+func TestHotEdges(t *testing.T) {
+	o := loadSource(t, map[string]string{"p.go": `package p
+
+func f() int { return g() + 1 }
+
+func g() int { return 2 }
+`})
+	f, g := analysistest.LookupNode(t, o.Graph, "f"), analysistest.LookupNode(t, o.Graph, "g")
+	o.Hot = map[*analysis.Node]bool{f: true, g: true}
+	o.HotEdges = analysis.BindHotNodes(o.Hot)
+	if len(o.HotEdges) != 1 || !o.HotEdges[analysis.Edge{From: g, To: f}] {
+		t.Fatalf("HotEdges = %v, want only g -> f", o.HotEdges)
+	}
+	if !g.Succs[f] {
+		t.Errorf("no edge g -> f")
+	}
+	if got, want := o.Fset.Position(o.refPos(analysis.Edge{From: g, To: f})).String(), o.Fset.Position(o.refPos(analysis.Edge{From: f, To: g})).String(); got != want {
+		t.Errorf("refPos(g -> f) = %s, want %s", got, want)
+	}
+
+	// Place f and g in different clusters.
+	f.Cluster = &analysis.Cluster{ImportPath: "p/f"}
+	g.Cluster = &analysis.Cluster{ImportPath: "p/g"}
+	var n int
+	stderr := captureStderr(t, func() { n = o.checkHotEdges(o.Hot) })
+	if n != 1 {
+		t.Errorf("checkHotEdges = %d, want 1:\n%s", n, stderr)
+	}
+	if want := "p.go:3:23: warning: hot-path edge f -> g crosses from p/f to p/g\n"; !strings.HasSuffix(stderr, want) {
+		t.Errorf("checkHotEdges printed %q, want suffix %q", stderr, want)
+	}
+}
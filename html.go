@@ -0,0 +1,139 @@
+package main
+
+// This file defines -html, which assembles the rendered SVG graphs
+// into a single self-contained page.
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// svgLinkRE matches the links among the rendered graphs.
+var svgLinkRE = regexp.MustCompile(`((?:xlink:)?href=")([^"/#:]+)\.svg"`)
+
+// writeHTML writes to the graph directory the page sockdrawer.html,
+// which embeds the rendered SVG graphs named by roots (e.g. "clusters")
+// and every graph reachable from them by links.  The roots are always
+// shown; each other graph is a pane, initially hidden, that following
+// a link to it shows, and that may be hidden again.  Links to godoc
+// and to the listings of large SCCs are unchanged.  With -docs, the
+// page ends with an index of the nodes of the clusters.
+func writeHTML(roots []string, clusters []*analysis.Cluster) error {
+	var buf bytes.Buffer
+	buf.WriteString(htmlHeader)
+
+	isRoot := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		isRoot[root] = true
+		seen[root] = true
+	}
+	for queue := append([]string(nil), roots...); len(queue) > 0; {
+		base := queue[0]
+		queue = queue[1:]
+		data, err := ioutil.ReadFile(filepath.Join(*graphdir, base+".svg"))
+		if err != nil {
+			return err
+		}
+		// Omit the XML declaration and doctype.
+		svg := string(data)
+		if i := strings.Index(svg, "<svg"); i >= 0 {
+			svg = svg[i:]
+		}
+		svg = svgLinkRE.ReplaceAllStringFunc(svg, func(link string) string {
+			m := svgLinkRE.FindStringSubmatch(link)
+			if !seen[m[2]] {
+				seen[m[2]] = true
+				queue = append(queue, m[2])
+			}
+			return m[1] + "#view-" + m[2] + `"`
+		})
+
+		if isRoot[base] {
+			fmt.Fprintf(&buf, "<div id=\"view-%s\" class=\"root\">\n%s\n</div>\n",
+				html.EscapeString(base), svg)
+			continue
+		}
+		fmt.Fprintf(&buf, "<div id=\"view-%s\" class=\"pane\">\n", html.EscapeString(base))
+		fmt.Fprintf(&buf, "<h2>%s <a href=\"#\" onclick=\"return hide(this)\">[hide]</a></h2>\n",
+			html.EscapeString(base))
+		fmt.Fprintf(&buf, "%s\n</div>\n", svg)
+	}
+	if *docs {
+		writeHTMLIndex(&buf, clusters)
+	}
+	buf.WriteString(htmlFooter)
+
+	filename := filepath.Join(*graphdir, "sockdrawer.html")
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0666); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s (%d graphs)\n", filename, len(seen))
+	return nil
+}
+
+// writeHTMLIndex writes an index of the nodes of each cluster, in
+// lexical order, with the first line of each node's doc comment.
+func writeHTMLIndex(buf *bytes.Buffer, clusters []*analysis.Cluster) {
+	buf.WriteString("<div class=\"index\">\n<h2>Index</h2>\n")
+	for _, c := range clusters {
+		fmt.Fprintf(buf, "<h3>%s</h3>\n<dl>\n", html.EscapeString(c.ImportPath))
+		for _, n := range analysis.SortedNodes(c.Nodes) {
+			fmt.Fprintf(buf, "<dt>%s</dt>", html.EscapeString(n.Name))
+			if doc := n.Doc(); doc != "" {
+				fmt.Fprintf(buf, "<dd>%s</dd>", html.EscapeString(strings.SplitN(doc, "\n", 2)[0]))
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString("</dl>\n")
+	}
+	buf.WriteString("</div>\n")
+}
+
+const htmlHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>sockdrawer</title>
+<style>
+.pane { display: none; border-top: 1px solid #ccc; }
+.pane.open { display: block; }
+h2 { font-family: sans-serif; font-size: medium; }
+h2 a { font-weight: normal; }
+.index { border-top: 1px solid #ccc; font-family: sans-serif; }
+.index dd { color: #555; }
+</style>
+</head>
+<body>
+`
+
+// The script opens the pane named by the URL fragment whenever a link
+// to it is followed, and scrolls to it.
+const htmlFooter = `<script>
+function show() {
+	var id = decodeURIComponent(location.hash.slice(1));
+	var pane = id && document.getElementById(id);
+	if (pane && pane.classList.contains("pane")) {
+		pane.classList.add("open");
+		pane.scrollIntoView();
+	}
+}
+function hide(link) {
+	link.parentNode.parentNode.classList.remove("open");
+	history.replaceState(null, "", "#");
+	return false;
+}
+window.addEventListener("hashchange", show);
+show();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHTMLIndex(t *testing.T) {
+	o := loadSource(t, map[string]string{"p.go": `package p
+
+// F does <nothing>.
+// It does it well.
+func F() {}
+
+func g() {}
+`})
+	clusters := partitionWith(t, o, "= p/f\nF\n")
+	var buf bytes.Buffer
+	writeHTMLIndex(&buf, clusters)
+	for _, want := range []string{
+		"<h3>p/f</h3>",
+		"<dt>F</dt><dd>F does &lt;nothing&gt;.</dd>",
+		"<h3>residue</h3>",
+		"<dt>g</dt>\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("index lacks %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+// This file defines the interactive refinement of the partition.
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// maxCandidates is the maximum number of residue scnodes offered
+// for assignment in each round of the interactive loop.
+const maxCandidates = 10
+
+// interact repeatedly offers the user the largest scnodes at the
+// bottom of the residue, prompting for the cluster to which each
+// should be assigned.  Each assignment is appended to the clusters
+// file, so progress is saved, and the partition is recomputed.
+// It returns the final partition.
+func (o *organizer) interact(clusters []*analysis.Cluster) ([]*analysis.Cluster, error) {
+	if *clusterFile == "" {
+		return nil, fmt.Errorf("-interactive requires -clusters")
+	}
+	in := bufio.NewScanner(os.Stdin)
+	skipped := make(map[string]bool) // names of skipped root nodes
+	for {
+		residue := clusters[len(clusters)-1]
+		if !residue.IsResidue() {
+			fmt.Fprintln(os.Stderr, "The residue is empty.")
+			return clusters, nil
+		}
+
+		var candidates []*analysis.SCNode
+		for s := range o.SCGraph(false) {
+			root := sccRoot(s)
+			if s.Cluster == residue && isBottom(s) && !skipped[root.Name] && !root.IsPinned() {
+				candidates = append(candidates, s)
+			}
+		}
+		if len(candidates) == 0 {
+			return clusters, nil
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			if x, y := len(candidates[i].Nodes), len(candidates[j].Nodes); x != y {
+				return x > y
+			}
+			return candidates[i].ID < candidates[j].ID
+		})
+		if len(candidates) > maxCandidates {
+			candidates = candidates[:maxCandidates]
+		}
+
+		fmt.Fprintln(os.Stderr, "\nClusters:")
+		for i, c := range clusters[:len(clusters)-1] {
+			fmt.Fprintf(os.Stderr, "\t%d\t%s\n", i, c.ImportPath)
+		}
+
+		var assigned bool
+		for _, s := range candidates {
+			root := sccRoot(s)
+			fmt.Fprintf(os.Stderr, "\nscnode of %d nodes:\n", len(s.Nodes))
+			for _, line := range strings.Split(s.String(), "\n") {
+				fmt.Fprintf(os.Stderr, "\t%s\n", line)
+			}
+			fmt.Fprintf(os.Stderr, "Assign %s to cluster (number, or import path of new cluster; s=skip, q=quit): ", root.Name)
+			if !in.Scan() {
+				return clusters, in.Err() // EOF
+			}
+			answer := strings.TrimSpace(in.Text())
+			switch answer {
+			case "", "s", "skip":
+				skipped[root.Name] = true
+				continue
+			case "q", "quit":
+				return clusters, nil
+			}
+			importPath := answer
+			if i, err := strconv.Atoi(answer); err == nil {
+				if i < 0 || i >= len(clusters)-1 {
+					fmt.Fprintf(os.Stderr, "no cluster %d; skipping\n", i)
+					continue
+				}
+				importPath = clusters[i].ImportPath
+			} else if importPath == o.Options.ResiduePath {
+				fmt.Fprintln(os.Stderr, "can't assign to residue; skipping")
+				continue
+			}
+			if err := addToClusterFile(*clusterFile, importPath, root.Name); err != nil {
+				return nil, err
+			}
+			assigned = true
+			break
+		}
+		if !assigned {
+			return clusters, nil // everything skipped
+		}
+
+		var err error
+		if clusters, err = o.partition(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isBottom reports whether s has no successors in its own cluster.
+func isBottom(s *analysis.SCNode) bool {
+	for succ := range s.Succs {
+		if succ.Cluster == s.Cluster {
+			return false
+		}
+	}
+	return true
+}
+
+// sccRoot returns the node of s that is named in the clusters file
+// to assign s: the lexically first node, preferring non-methods.
+func sccRoot(s *analysis.SCNode) *analysis.Node {
+	var root *analysis.Node
+	for n := range s.Nodes {
+		switch {
+		case root == nil,
+			root.IsMethod() && !n.IsMethod(),
+			root.IsMethod() == n.IsMethod() && n.ID < root.ID:
+			root = n
+		}
+	}
+	return root
+}
+
+// addToClusterFile adds the node name to the stanza for importPath
+// in the specified clusters file, appending a new stanza if needed.
+func addToClusterFile(filename, importPath, name string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+
+	// Find the end of the stanza, ignoring trailing blank lines.
+	at := -1
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "= ") {
+			if at >= 0 {
+				break // start of next stanza
+			}
+			if strings.TrimSpace(line[2:]) == importPath {
+				at = i + 1
+			}
+		} else if at >= 0 && line != "" {
+			at = i + 1
+		}
+	}
+	if at >= 0 {
+		lines = append(lines[:at], append([]string{name}, lines[at:]...)...)
+	} else {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "= "+importPath, name)
+	}
+	return ioutil.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0666)
+}
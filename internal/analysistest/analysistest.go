@@ -0,0 +1,112 @@
+// Package analysistest provides helpers for the tests of the analysis
+// package and of the sockdrawer command: they load small packages
+// from source and inspect their node graphs and partitions.
+package analysistest
+
+import (
+	"go/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/arl/sockdrawer/analysis"
+	"golang.org/x/tools/go/loader"
+)
+
+// WriteFiles writes the files, keyed by name, to a new temporary
+// directory, and returns it.
+func WriteFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// LoadProgram loads the package formed by the .go files of dir, as the
+// command does for files named on the command line.
+func LoadProgram(t *testing.T, dir string) *loader.Program {
+	t.Helper()
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(filenames)
+	conf := loader.Config{ParserMode: parser.ParseComments}
+	if _, err := conf.FromArgs(filenames, false); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := conf.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+// Load loads the package formed by the .go files of dir, and builds
+// its node graph with the options.
+func Load(t *testing.T, opts analysis.Options, dir string) *analysis.Graph {
+	t.Helper()
+	prog := LoadProgram(t, dir)
+	return analysis.NewGraph(prog.Fset, prog.InitialPackages()[0], nil, opts)
+}
+
+// LoadSource loads the package formed by the files, keyed by name,
+// and builds its node graph with the options.
+func LoadSource(t *testing.T, opts analysis.Options, files map[string]string) *analysis.Graph {
+	t.Helper()
+	return Load(t, opts, WriteFiles(t, files))
+}
+
+// WriteClusters writes a clusters file of the specified content,
+// and returns its name.
+func WriteClusters(t *testing.T, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "test.clusters")
+	if err := ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// Partition partitions g by the clusters file of the specified
+// content.
+func Partition(t *testing.T, g *analysis.Graph, clustersFile string) []*analysis.Cluster {
+	t.Helper()
+	clusters, err := g.Partition(WriteClusters(t, clustersFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clusters
+}
+
+// LookupNode returns the node of g of the specified name.
+func LookupNode(t *testing.T, g *analysis.Graph, name string) *analysis.Node {
+	t.Helper()
+	for _, n := range g.Nodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	t.Fatalf("no node %s", name)
+	return nil
+}
+
+// SuccNames returns the names of the successors of n, sorted.
+func SuccNames(n *analysis.Node) string {
+	var names []string
+	for _, succ := range analysis.SortedNodes(n.Succs) {
+		names = append(names, succ.Name)
+	}
+	return strings.Join(names, " ")
+}
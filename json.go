@@ -0,0 +1,57 @@
+package main
+
+// This file emits the partition in JSON form (-json),
+// for consumption by other tools.
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// jsonCluster is the JSON form of a cluster.
+type jsonCluster struct {
+	ImportPath string     `json:"importPath"`
+	Nodes      []jsonNode `json:"nodes"`
+}
+
+// jsonNode is the JSON form of a node.
+type jsonNode struct {
+	Name     string `json:"name"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Exported bool   `json:"exported"`
+	Recv     string `json:"recv,omitempty"` // receiver type of a method
+	Kind     string `json:"kind"`           // func, method, const, var or type
+	Doc      string `json:"doc,omitempty"`  // doc comment (-docs)
+}
+
+// writeJSON writes the partition to w as a JSON array of clusters,
+// in topological order, each with its nodes in lexical order.
+func (o *organizer) writeJSON(w io.Writer, clusters []*analysis.Cluster) error {
+	result := make([]jsonCluster, 0, len(clusters))
+	for _, c := range clusters {
+		jc := jsonCluster{ImportPath: c.ImportPath, Nodes: []jsonNode{}}
+		for _, n := range analysis.SortedNodes(c.Nodes) {
+			posn := o.Fset.Position(n.Pos())
+			jn := jsonNode{
+				Name:     n.Name,
+				File:     posn.Filename,
+				Line:     posn.Line,
+				Exported: n.Exportedness() > 0,
+				Kind:     n.Kind(),
+			}
+			if *docs {
+				jn.Doc = strings.TrimSpace(n.Doc())
+			}
+			jn.Recv = n.RecvType()
+			jc.Nodes = append(jc.Nodes, jn)
+		}
+		result = append(result, jc)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(result)
+}
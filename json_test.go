@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONDocs(t *testing.T) {
+	o := loadSource(t, map[string]string{"p.go": `package p
+
+// F does nothing.
+// It does it well.
+func F() {}
+
+func g() {}
+`})
+	clusters := partitionWith(t, o, "")
+	setFlag(t, "docs", "true")
+	var buf bytes.Buffer
+	if err := o.writeJSON(&buf, clusters); err != nil {
+		t.Fatal(err)
+	}
+	var result []jsonCluster
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	docs := make(map[string]string)
+	for _, n := range result[0].Nodes {
+		docs[n.Name] = n.Doc
+	}
+	if want := "F does nothing.\nIt does it well."; docs["F"] != want {
+		t.Errorf("doc of F = %q, want %q", docs["F"], want)
+	}
+	if docs["g"] != "" {
+		t.Errorf("doc of g = %q, want none", docs["g"])
+	}
+}
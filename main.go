@@ -23,43 +23,332 @@ import (
 	"flag"
 	"fmt"
 	"go/parser"
-	"go/token"
 	"go/types"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 
+	"github.com/arl/sockdrawer/analysis"
 	"golang.org/x/tools/go/loader"
 )
 
-const debug = false
-
 var (
-	clusterFile = flag.String("clusters", "", "File containing cluster annotations")
-	print       = flag.Bool("print", false, "Print the partition to stdout")
-	outdir      = flag.String("outdir", "", "enable package splitting, using this output directory")
-	graphdir    = flag.String("graphdir", "", "enable graph rendering, using this output directory")
-	fuse        = flag.Bool("fuse", false, "fuse each single-predecessor SCC with its sole predecessor; this reduces the complexity of the output graphs")
-	godoc       = flag.String("godoc", "http://localhost:4999", "base URL for godoc server")
+	verbose      = flag.Bool("v", false, "print progress and statistics to stderr")
+	veryVerbose  = flag.Bool("vv", false, "like -v, but also trace the cluster assignment of each node")
+	clusterFile  = flag.String("clusters", "", "File containing cluster annotations")
+	print        = flag.Bool("print", false, "Print the partition to stdout")
+	numBands     = flag.Int("k", 0, "absent a clusters file, partition the package into about this many balanced clusters")
+	hotPath      = flag.String("hotpath", "", "file listing performance-critical nodes to keep together")
+	residuePath  = flag.String("residue-path", "residue", "import path of the residue cluster, e.g. that of the original package")
+	pathTemplate = flag.String("path-template", "{{.PkgPath}}/{{.ClusterName}}", "template for the import paths of generated clusters")
+	tests        = flag.Bool("tests", false, "include the package's tests in the analysis, but not in the refactored output")
+	configList   = flag.String("configs", "", "comma-separated goos/goarch build configurations to analyze, primary first")
+	cacheFile    = flag.String("cache", "", "file in which to keep the node graph between runs, reusing it while the sources are unchanged")
+	colocate     = flag.Bool("colocate", false, "assign unassigned members of declaration groups to the cluster of the other members")
+	pinInit      = flag.Bool("pin-init", false, "keep init functions in the residue, preserving initialization order")
+	watch        = flag.Bool("watch", false, "keep the analysis resident, recomputing the partition and output whenever the clusters file changes")
+	repl         = flag.Bool("repl", false, "keep the analysis resident, recomputing the partition and output on demand")
+	splitMethods = flag.Bool("split-methods", false, "let a stanza that names a method assign it apart from its receiver type")
+	check        = flag.Bool("check", false, "fail if the clusters file declares its stanzas out of order")
+	strict       = flag.Bool("strict", false, "fail if a stanza of the clusters file assigns no nodes")
+	moduleMode   = flag.Bool("module-mode", false, "print and render the import graph among all the specified packages")
+	suggest      = flag.Bool("suggest", false, "print proposed clusters for the residue as clusters-file stanzas")
+	interactive  = flag.Bool("interactive", false, "interactively assign bottom residue scnodes to clusters, updating the clusters file")
+	docs         = flag.Bool("docs", false, "include each node's doc comment in -print, -json and -html output")
+	outdir       = flag.String("outdir", "", "enable package splitting, using this output directory")
+	exportRules  = flag.String("export-rules", "", "file of rules for naming exported identifiers")
+	varAccessors = flag.Bool("var-accessors", false, "access unexported variables from other clusters through generated functions instead of exporting them")
+	shims        = flag.Bool("shims", false, "forward the exported symbols moved out of the residue by declarations in the residue")
+	coalesce     = flag.Bool("coalesce", false, "write each cluster's declarations to a single file named after it, instead of one file per source file")
+	dryRun       = flag.Bool("dry-run", false, "print the files that -outdir would write, and their node counts, without writing them")
+	clean        = flag.Bool("clean", false, "remove the files of the previous output that the current one lacks")
+	gitmv        = flag.Bool("gitmv", false, "write a script of 'git mv' commands recording source files as renamed to their main output files")
+	verify       = flag.Bool("verify", false, "build the refactored output with the go command, reporting errors by cluster")
+	force        = flag.Bool("force", false, "split the package even if all nodes are in the residue")
+	provenance   = flag.Bool("provenance", false, "add a comment recording the origin of each generated file")
+	genTestStubs = flag.Bool("gen-test-stubs", false, "write a placeholder test file into each new subpackage")
+	asmStub      = flag.Bool("asm-stub", true, "write an empty .s file into each output package that declares bodiless functions")
+	graphdir     = flag.String("graphdir", "", "enable graph rendering, using this output directory")
+	expandLevel  = flag.String("expand-level", "clusters", "pre-expand the rendered graph down to this level (clusters, scnodes, nodes)")
+	methodEdges  = flag.String("show-method-edges", "all", "how to draw type/method edges in node graphs (all, real, both, none)")
+	maxNodes     = flag.Int("maxnodes", 500, "render SCCs of more than this many nodes as a placeholder (0 means no limit)")
+	groupMethods = flag.Bool("group-methods", false, "box each type together with its methods in node graphs")
+	kindColor    = flag.String("kind-colors", "", "override the fill colors of node kinds in node graphs, e.g. type=#ffc0c0,var=yellow")
+	hideIsolated = flag.Bool("hide-isolated", false, "omit nodes without edges from rendered graphs")
+	fuse         = flag.Bool("fuse", false, "fuse each single-predecessor SCC with its sole predecessor; this reduces the complexity of the output graphs")
+	fuseExports  = flag.Bool("fuse-respect-exports", false, "with -fuse, don't fuse scnodes with exported members into ones without, or vice versa")
+	dotCmd       = flag.String("dot", "", "path of the graphviz dot command (default: dot on the PATH)")
+	graphFormat  = flag.String("graph-format", "svg", "format of rendered graphs (svg, png, pdf)")
+	htmlView     = flag.Bool("html", false, "also assemble the rendered SVG graphs into a single HTML page")
+	font         = flag.String("font", "", "font of rendered graphs, as family[:size]")
+	dpi          = flag.Int("dpi", 0, "resolution of rendered graphs, in dots per inch")
+	godoc        = flag.String("godoc", "http://localhost:4999", "base URL for godoc server, or none")
+	godocStyle   = flag.String("godoc-style", "classic", "URL layout of the -godoc server (classic, pkgsite)")
+	byFile       = flag.Bool("by-file", false, "print the clusters among which each source file is distributed")
+	fileReport   = flag.Bool("file-report", false, "print the source files wholly or partly in each cluster")
+	apiSurface   = flag.Bool("api-surface", false, "print the symbols each cluster must export to the others")
+	exportReport = flag.Bool("export-report", false, "print the identifiers that the split forces to be exported, by pair of clusters")
+	order        = flag.Bool("order", false, "print the order in which to split off the clusters into subpackages")
+	tree         = flag.Bool("tree", false, "print the cluster DAG as indented text, lowest cluster first")
+	stats        = flag.Bool("stats", false, "print the size and coupling of each cluster")
+	sccWarn      = flag.Int("scc-warn", 0, "warn of each SCC of more than this many nodes, naming likely places to cut it")
+	sccCuts      = flag.String("scc-cuts", "", "print a small set of edges whose removal breaks up the SCC of this node")
+	feedback     = flag.Bool("feedback-edges", false, "print a small set of edges whose removal makes the node graph acyclic")
+	verifyAPI    = flag.Bool("verify-api", false, "fail if the split makes exported symbols inaccessible to external importers")
+	safety       = flag.Bool("safety-report", false, "print a checklist of refactoring risks present in the package")
+	progress     = flag.String("progress", "", "print the change in summary statistics since the snapshot in this file")
+	snapshotFile = flag.String("snapshot", "", "write the summary statistics of the partition to this file")
+	jsonOut      = flag.Bool("json", false, "print the partition to stdout in JSON form")
+	dump         = flag.String("dump", "", "print an intermediate representation (nodes, sccs, clusters) in a line-oriented format")
+	outFormat    = flag.String("format", "", "print a report on the partition in this format (matrix, graphml)")
 )
 
 const Usage = `Usage: sockdrawer -clusters=file [flags...] <args>
 
 sockdrawer is a tool for splitting a package into two or more subpackages.
 
+General flags:
+ -v			Print progress and statistics (node and SCC counts,
+			clusters) to stderr.
+ -vv			Like -v, but also trace the cluster assignment of each
+			node, direct or indirect.
+
+Module flags:
+ -module-mode		Instead of splitting one package, print the import graph among
+			all the specified packages (e.g. ./...) and their tests, and
+			the import cycles formed through tests; with -graphdir, also
+			render it as packages.svg.
+
 Partition flags:
  -clusters=file		Load the cluster definitions from the specified file.
+ -k=N			Absent a clusters file, split the package into about N
+			clusters of similar size, named part0...partN-1.
+ -hotpath=file		Keep the performance-critical nodes listed in this file together
+			and warn about dependencies among them that cross clusters.
+ -configs=list		Analyze the package under each of these comma-separated
+			build configurations, e.g. linux/amd64,windows/arm.  The
+			first is refactored; the others contribute their edges
+			between nodes of the same names.  -print marks nodes not
+			declared under all of them, and lists those absent from
+			the first.
+ -tests			Include the package's _test.go files, and its external test
+			package if any, in the node graph.  Test declarations are
+			shown in reports and graphs but omitted from -outdir output.
+ -cache=file		Keep the node graph in this file, keyed by a hash of the names,
+			modification times and contents of the package's source
+			files, and while none of them changes, reuse it instead of
+			loading and type-checking the package.  Node names and IDs
+			are as in the original run, and -print, -json, -graphdir and
+			the other reports are unchanged.  Output that requires
+			syntax or types (-outdir, -docs, -colocate, -var-accessors,
+			-api-surface, -export-report, -stats, -verify-api,
+			-safety-report, or several -configs) bypasses the cached graph, but updates
+			it.  Changes to imported packages do not invalidate it.
+ -split-methods		Let a stanza that names a concrete method, as "(T).f",
+			assign it apart from its receiver type, which otherwise
+			keeps it.  Without this flag, naming a method whose type
+			is in another cluster is reported and ignored.
+ -check			Fail if the stanzas of the clusters file are out of bottom-to-top
+			order, i.e. a node named in a stanza is already claimed by a
+			cluster declared earlier.
+ -strict		Fail if a stanza of the clusters file assigns no nodes, e.g.
+			because all its names are mistyped, rather than merely
+			warning of the empty cluster.
+ -residue-path=path	The import path of the residue, and thus the directory and
+			package name of its output.  Default: residue.  Use the
+			original package's path to keep its remains importable
+			under the same name.
+ -path-template=tmpl	The Go template for the import paths of generated clusters
+			(e.g. by -k), with fields .PkgPath and .ClusterName.
+			Default: {{.PkgPath}}/{{.ClusterName}}.
+ -colocate		Assign each unassigned member of a const(...), var(...) or
+			type(...) group, and the unassigned nodes it uses, to
+			the cluster of the group's other members, unless that
+			would create an upward reference.  -print marks these
+			assignments [colocated].
+ -pin-init		Keep init functions in the residue.  (func main is always kept.)
+ -watch			After the output, watch the clusters file (and the files it
+			includes), and whenever it changes, reload it and emit the
+			output again, without reanalyzing the package: a faster
+			edit-and-rerun loop.  The file is polled twice a second.
+ -repl			After the output, wait for commands to reload the clusters file
+			and emit the output again, without reanalyzing the package.
+ -suggest		Print proposed clusters for the residue, largest first, as
+			stanzas ready to paste into the clusters file.  Each is
+			a stem scnode and the residue scnodes below it that only
+			it uses.
+ -interactive		Prompt for the cluster of each of the largest scnodes at the
+			bottom of the residue, appending to the clusters file.
 
 Display flags:
  -print                 Print the partition in text form to the standard output.
+ -docs			Show each node's doc comment: its first line in -print
+			output and the -html index, and all of it in -json output.
  -graphdir=dir		Render graphs of the proposed split to this directory.
  -godoc=url		In rendered graphs, emit links to godoc at this address.
+			With -godoc=none (or empty), nodes link nowhere, as suits
+			graphs for offline review.
+ -godoc-style=style	The URL layout of the -godoc server: classic (default), the
+			legacy godoc, linking to each declaration's source; or
+			pkgsite, as used by pkg.go.dev, linking to its symbol's
+			documentation, e.g. #T.Method.
+ -expand-level=level	Also render combined.svg, showing clusters expanded down to
+			this level: clusters (default; no combined view), scnodes, nodes.
+ -show-method-edges=mode
+			How to draw the edges between a type and its methods in
+			node graphs.  all (default): every edge, including the
+			synthetic edge from the type to each method that keeps them
+			in the same SCC; real: only the edge from each method to its
+			receiver type, which arises from its signature; both: the
+			two as a single double-headed edge; none: neither, to focus
+			on genuine data and call dependencies.
+ -group-methods		In node graphs, draw each type and its concrete methods in a
+			dashed box of their own.  This affects only the rendering,
+			not the partition.
+ -kind-colors=list	Override the fill colors by which node graphs distinguish the
+			kinds of declarations, given as a comma-separated list of
+			kind=color pairs, e.g. "type=#ffc0c0,var=yellow".  The kinds
+			and their default colors are func (lavender), method
+			(purple), type (orange), var (yellow) and const (green).
+ -maxnodes=N		Render each SCC of more than N nodes (default 500) as a
+			placeholder linking to a list of its nodes, since dot is
+			slow on large graphs and their renderings unreadable.
+			0 means no limit.
+ -hide-isolated		Omit nodes without any edges from rendered graphs.
+			(They still appear in -print output.)
  -fuse			Display each single-predecessor SCC fused to its sole predecessor.
+ -fuse-respect-exports	With -fuse, don't fuse an SCC with exported members into one
+			without, or vice versa, keeping API boundaries visible.
+ -dot=path		The graphviz dot command (default: "dot" on the PATH).
+ -graph-format=fmt	Render graphs in this format: svg (default), png or pdf.
+ -html			Also write sockdrawer.html to the graph directory: a single
+			page showing the graph of clusters, in which following a
+			link opens the linked graph in a collapsible pane below.
+			With -docs, the page ends with an index of the nodes of
+			each cluster and their doc comments.
+ -font=family[:size]	Use this font in rendered graphs, e.g. "Helvetica:14".
+ -dpi=N			Render graphs at this resolution, in dots per inch.
+ -json			Print the partition as a JSON array of clusters, each with
+			its import path and nodes (name, file, line, exported, recv,
+			kind, and with -docs, doc).
+ -dump=what		Print an intermediate representation of the analysis in a
+			stable line-oriented format: the node graph (nodes), the
+			strongly connected components (sccs), or the partition
+			(clusters).
+ -format=matrix		Print the matrix of edge counts between clusters.
+ -format=graphml	Print the cluster graph in GraphML format.
+ -by-file		Print the clusters among which each source file's
+			declarations are distributed.
+ -file-report		Print the source files of each cluster, distinguishing files
+			wholly in that cluster from those split across clusters.
+ -api-surface		Print the symbols of each cluster referenced by other clusters,
+			with their exported names.
+ -export-report		Print the unexported identifiers that must become exported
+			only because of the split, with their total, grouped by
+			the pair of clusters (user -> definer) whose references
+			require it, to help judge the cost of a partition.  The
+			identifiers exported for other reasons, such as embedding
+			or unkeyed struct literals, are listed separately.
+ -order			Print the clusters in the order in which to split them off
+			into subpackages, lowest first, flagging those that depend
+			on the residue or on each other cyclically.
+ -tree			Print the cluster DAG as text, lowest cluster first, each
+			indented by its dependency depth and followed by the
+			clusters it depends on: a view needing no graphviz.
+ -stats			Print the size and coupling of each cluster: its nodes,
+			scnodes, source lines, nodes that must be exported, and
+			edges in from and out to other clusters.
+ -scc-warn=N		Warn of each strongly connected component of more than N
+			nodes, a circularity in the design that no split can
+			divide, naming its exported and most depended-upon nodes
+			as the likeliest places to break it.
+ -scc-cuts=node		Print a small set of references within the strongly connected
+			component of the named node whose removal would make it
+			acyclic, each a candidate for decoupling, e.g. by
+			dependency injection.
+ -feedback-edges	Print a small set of references whose removal would make the
+			node graph acyclic: candidates for dependency-breaking refactors.
+ -verify-api		Report exported symbols that the split would move out of the
+			residue, failing if any becomes inaccessible (internal).
+ -safety-report		Print a checklist of constructs the refactoring doesn't fully
+			handle that are present in the package.
+ -snapshot=file		Write the summary statistics of the partition (cluster count,
+			residue size, cross-cluster edges) to this JSON file.
+ -progress=file		Print the change in summary statistics since the snapshot
+			in this file; may name the same file as -snapshot.
 
 Refactoring flags:
  -outdir=dir		Split the package into subpackages, writing them here.
+			Files whose content is unchanged are not rewritten.
+ -export-rules=file	Rename identifiers that must become exported using the rules
+			in this file, before capitalization.  Each line holds a regular
+			expression matching the whole name and its replacement, e.g.
+			"http(.*) HTTP$1".
+ -var-accessors		Instead of exporting an unexported variable used by other
+			clusters, declare a getter (and, if they assign it, a setter)
+			in its cluster and call these from the others.  Variables
+			whose address is taken, or that are modified in place by
+			other clusters, are still exported.
+ -shims			Declare in the residue's shims.go a forwarding declaration
+			for each exported symbol moved to another cluster, so
+			that importers need not change: a function calling the
+			original, a constant or variable initialized to it, or a
+			type alias.  Generic symbols, variables the package
+			assigns, and symbols whose types mention unexported
+			types of other clusters are reported instead.  With
+			-verify-api, shimmed symbols count as accessible.
+ -coalesce		Write the declarations of each cluster to a single file named
+			after its package, e.g. low.go, in declaration order,
+			instead of to one output file per source file.  Source
+			files built only in some configurations, by their names
+			(e.g. x_linux.go), build constraints or use of cgo, still
+			get output files of their own.  The coalesced file begins
+			with the header comment of the first source file.  A
+			cluster whose imports would conflict within one file, or
+			that uses a dot import, keeps one file per source file.
+ -dry-run		Do everything -outdir does, reporting the same warnings, but
+			instead of writing the output, print the files it would
+			write and the number of nodes in each.
+ -clean			Remove from the output directory the files of the previous
+			output, as recorded by the manifest .sockdrawer-files,
+			that the current output lacks, e.g. those of a renamed
+			cluster, and the directories they leave empty.  No
+			other file is removed.  With -dry-run, list them.
+ -gitmv			Write to the output directory a script, gitmv.sh, that
+			'git mv's each source file over the output file of the
+			same name in the cluster holding most of its declarations,
+			so that git records a rename plus an edit, preserving the
+			file's history.  (With -coalesce, only the first of the
+			source files sharing an output file is moved.)  The output
+			directory must lie within the source repository.
+ -verify		After writing the output, build its packages with 'go build'
+			and report the errors under the cluster and output file in
+			which they arise.  If the package lies within a module, the
+			build is in module mode: an overlay replaces the package by
+			the output within the module, and a temporary go.work adds
+			a module for each cluster whose import path lies outside
+			it.  Otherwise it is in GOPATH mode, the output directory
+			serving as the src directory of a temporary workspace ahead
+			of $GOPATH.
+ -force			Write the output even if no split was specified, i.e. all
+			nodes are in the residue.
+ -provenance		Begin each generated file with a comment recording the
+			source file and clusters file from which it was generated.
+ -gen-test-stubs	Write a placeholder <pkg>_test.go into each new subpackage.
+ -asm-stub		Write an empty asm_stub.s into each subpackage that declares
+			functions without bodies, suppressing "missing function body"
+			errors until link time (default true).
 ` + loader.FromArgsUsage
 
+// logf prints a message to stderr if the verbosity
+// level (-v=1, -vv=2) is at least the specified level.
+func logf(level int, format string, args ...interface{}) {
+	if options().Verbosity >= level {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
 func main() {
 	flag.Parse()
 	args := flag.Args()
@@ -76,111 +365,484 @@ func doMain(args []string) error {
 	}
 
 	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, Usage)
+		fmt.Fprint(os.Stderr, Usage)
 		return nil
 	}
 
+	if _, _, err := parseFont(*font); err != nil {
+		return err
+	}
+	switch *graphFormat {
+	case "svg", "png", "pdf":
+	default:
+		return fmt.Errorf("unknown -graph-format: %q", *graphFormat)
+	}
+	if *htmlView && *graphFormat != "svg" {
+		return fmt.Errorf("-html requires -graph-format=svg")
+	}
+	if *kindColor != "" {
+		if err := parseKindColors(*kindColor); err != nil {
+			return err
+		}
+	}
+	switch *godocStyle {
+	case "classic", "pkgsite":
+	default:
+		return fmt.Errorf("unknown -godoc-style: %q", *godocStyle)
+	}
+	if why := analysis.CheckImportPath(*residuePath); why != "" {
+		return fmt.Errorf("invalid -residue-path %q: %s", *residuePath, why)
+	}
+
+	// Analyze several build configurations?
+	var configs []buildConfig
+	if *configList != "" {
+		var err error
+		if configs, err = parseConfigs(*configList); err != nil {
+			return err
+		}
+		conf.Build = configs[0].context()
+	}
+
+	// In module mode, the tests are needed for their imports.
+	if *moduleMode {
+		var err error
+		if args, err = expandPatterns(args); err != nil {
+			return err
+		}
+	}
+
 	// Use the initial packages from the command line.
-	// TODO(adonovan): support *_test.go files too.
-	_, err := conf.FromArgs(args, false /*FIXME*/)
+	_, err := conf.FromArgs(args, *tests || *moduleMode)
 	if err != nil {
 		return err
 	}
 
+	// Reuse the node graph of a previous run?
+	var cacheKey string
+	if *cacheFile != "" && !*moduleMode {
+		filenames, err := sourceFiles(&conf)
+		if err != nil {
+			return err
+		}
+		if cacheKey, err = analysis.CacheKey(filenames); err != nil {
+			return err
+		}
+		if canUseCache(configs) {
+			g, err := analysis.ReadCache(*cacheFile, cacheKey, options())
+			if err != nil {
+				return err
+			}
+			if g != nil {
+				logf(1, "Using the node graph cached in %s\n", *cacheFile)
+				return sockdrawer(g, configs, nil)
+			}
+		}
+	}
+
 	// Typecheck only the necessary function bodies.
 	// TODO(adonovan): opt: type-check only the bodies of functions
 	// with the initial packages.
 	conf.TypeCheckFuncBodies = func(p string) bool { return true }
 
+	// Load the package under the other build configurations.
+	var graphs []*analysis.ConfigGraph
+	if len(configs) > 1 && !*moduleMode {
+		if graphs, err = loadConfigGraphs(&conf, configs[1:]); err != nil {
+			return err
+		}
+	}
+
 	// Load, parse and type-check the whole program.
 	iprog, err := conf.Load()
 	if err != nil {
 		return err
 	}
 
-	// TODO(adonovan): fix: generalize to multiple packages, or at least,
-	// one package plus its external test package.
-	info := iprog.InitialPackages()[0]
-	return sockdrawer(conf.Fset, info)
+	if *moduleMode {
+		return moduleView(iprog)
+	}
+
+	// Using the AST and Ident-to-Object mapping,
+	// build the dependency graph over package-level nodes.
+	info, xtest := analysis.InitialPackages(iprog, *tests)
+	g := analysis.NewGraph(conf.Fset, info, xtest, options())
+	if cacheKey != "" {
+		if err := g.WriteCache(*cacheFile, cacheKey); err != nil {
+			return err
+		}
+	}
+	return sockdrawer(g, configs, graphs)
 }
 
+// An organizer holds the node graph of the package, and the state of
+// its refactoring into the clusters of a partition.
 type organizer struct {
-	fset       *token.FileSet
-	info       *loader.PackageInfo
-	nodes      []*node // nodes for top-level decls/specs, in lexical order
-	nodesByObj map[types.Object]*node
+	*analysis.Graph
+
+	// exportNames holds the new names for objects
+	// that must become exported; see computeExports.
+	exportNames map[types.Object]string
+
+	// accessors holds the getters and setters through which other
+	// clusters use unexported variables (-var-accessors).
+	accessors map[types.Object]*accessor
+
+	// grouping assigns declarations to output files; see split.
+	grouping fileGrouping
+
+	// The remaining fields describe the output of each cluster and
+	// node; see computeExports and split.
+	clusterNames map[*analysis.Cluster]string                    // import name of each cluster, e.g. "core"
+	scopes       map[*analysis.Cluster]map[string]*analysis.Node // package-level names of each cluster
+	outputFiles  map[*analysis.Cluster]map[string]*outputFile    // output files of each cluster, keyed by base name
+	mustExport   map[*analysis.Node]bool                         // nodes that must be exported to other clusters
+	nodeImports  map[*analysis.Node]map[interface{}]bool         // existing (importSpec) and new (*Cluster) dependencies of each node
 }
 
-func sockdrawer(fset *token.FileSet, info *loader.PackageInfo) error {
-	o := organizer{
-		fset:       fset,
-		info:       info,
-		nodesByObj: make(map[types.Object]*node),
+// options returns the analysis options specified by the flags.
+func options() analysis.Options {
+	opts := analysis.Options{
+		ResiduePath:  *residuePath,
+		PathTemplate: *pathTemplate,
+		Bands:        *numBands,
+		HotPath:      *hotPath,
+		Tests:        *tests,
+		PinInit:      *pinInit,
+		SplitMethods: *splitMethods,
+		Colocate:     *colocate,
+		FuseExports:  *fuseExports,
+		Log:          stderr{},
 	}
+	if *veryVerbose {
+		opts.Verbosity = 2
+	} else if *verbose {
+		opts.Verbosity = 1
+	}
+	return opts
+}
 
-	// Using the AST and Ident-to-Object mapping,
-	// build the dependency graph over package-level nodes.
-	o.buildNodeGraph()
+// stderr writes to os.Stderr as it is at the time of each write, so
+// that the tests may capture the warnings of the analysis.
+type stderr struct{}
+
+func (stderr) Write(p []byte) (int, error) { return os.Stderr.Write(p) }
+
+// partition loads the clusters file, if any, and returns the implied
+// partition in topological order, residue last.
+// Any previous partition, and its refactoring state, is discarded.
+func (o *organizer) partition() ([]*analysis.Cluster, error) {
+	o.exportNames = nil
+	o.accessors = nil
+	o.clusterNames = make(map[*analysis.Cluster]string)
+	o.scopes = make(map[*analysis.Cluster]map[string]*analysis.Node)
+	o.outputFiles = make(map[*analysis.Cluster]map[string]*outputFile)
+	o.mustExport = make(map[*analysis.Node]bool)
+	o.nodeImports = make(map[*analysis.Node]map[interface{}]bool)
+	return o.Partition(*clusterFile)
+}
+
+func sockdrawer(g *analysis.Graph, configs []buildConfig, graphs []*analysis.ConfigGraph) error {
+	if *repl && *outdir != "" {
+		// Refactoring modifies the syntax trees in place.
+		return fmt.Errorf("-repl and -outdir are mutually exclusive")
+	}
+	if *watch {
+		if *outdir != "" || *repl {
+			return fmt.Errorf("-watch is incompatible with -outdir and -repl")
+		}
+		if *clusterFile == "" {
+			return fmt.Errorf("-watch requires -clusters")
+		}
+	}
+
+	o := organizer{Graph: g}
+	for _, n := range o.Nodes {
+		if n.IsPinned() {
+			fmt.Fprintf(os.Stderr, "%s: note: pinning %s to the residue\n",
+				o.Fset.Position(n.Pos()), n.Name)
+		}
+	}
+
+	// Merge the node graphs of other build configurations?
+	if configs != nil {
+		o.MergeConfigs(configs[0].String(), graphs)
+	}
+
+	// Keep performance-critical nodes together?
+	if *hotPath != "" {
+		if err := o.LoadHotNodes(*hotPath); err != nil {
+			return err
+		}
+	}
 
 	// Load the clusters file, if any,
 	// and compute the implied partition.
-	var clusters []*cluster // topological order
-	if f := *clusterFile; f != "" {
-		var err error
-		if clusters, err = loadClusterFile(f, o.nodes); err != nil {
+	clusters, err := o.partition()
+	if err != nil {
+		return err
+	}
+
+	// Refine the partition interactively?
+	if *interactive {
+		if clusters, err = o.interact(clusters); err != nil {
 			return err
 		}
 	}
-	clusters = addResidualCluster(o.nodes, clusters)
 
+	// Check the partition.
+	// Violations are reported as an error once all output is done.
+	failure := o.checkPartition(clusters)
+
+	// Print and render the partition.
+	if err := o.display(clusters); err != nil {
+		return err
+	}
+
+	// Do the refactoring?
+	if *outdir != "" {
+		if isUnsplit(clusters) && !*force {
+			// Everything is in the residue: the output would be
+			// a useless copy of the package.
+			return fmt.Errorf("no split specified: all nodes are in the residue " +
+				"(use -clusters or -k, or -force to write it anyway)")
+		}
+		if err := o.refactor(clusters); err != nil {
+			return err
+		}
+	}
+
+	printSummary(o.Nodes, clusters)
+
+	// Keep the node graph resident for further iterations?
+	if *watch {
+		return o.watch()
+	}
+	if *repl {
+		return o.repl(clusters)
+	}
+
+	return failure
+}
+
+// display prints and renders the partition as requested by the flags.
+func (o *organizer) display(clusters []*analysis.Cluster) error {
 	// Print the partition?
 	if *print {
-		// Use the same format as the clusters file.
-		fmt.Printf("# Package: %q\n", info.Pkg.Path())
-		fmt.Printf("# Initial cluster file: %q\n", *clusterFile)
-		fmt.Printf("# %d nodes in %d clusters\n", len(o.nodes), len(clusters))
-		fmt.Println()
+		o.printPartition(clusters)
+	}
 
-		for _, c := range clusters {
-			var ss []string
-			for n := range c.nodes {
-				posn := n.o.fset.Position(n.syntax.Pos())
-				base := filepath.Base(posn.Filename)
-				// Comment out concrete method nodes since they can't be
-				// specified in cluster file syntax.
-				// (They're tied to their receiver type's cluster anyway.)
-				var comment string
-				if n.recv != nil {
-					comment = "# "
-				}
-				ss = append(ss, fmt.Sprintf("%s%-40s# %s:%d", comment, n.name, base, posn.Line))
+	// Print the source files of each cluster?
+	if *fileReport {
+		printFileReport(o.Nodes, clusters)
+	}
+
+	// Print the clusters of each source file?
+	if *byFile {
+		printByFile(o.Nodes, clusters)
+	}
+
+	// Print the API surface of each cluster?
+	if *apiSurface {
+		if err := o.computeExports(clusters); err != nil {
+			return err
+		}
+		o.printAPISurface(clusters)
+	}
+
+	// Print the identifiers exported because of the split?
+	if *exportReport {
+		if err := o.computeExports(clusters); err != nil {
+			return err
+		}
+		o.printExportReport(clusters)
+	}
+
+	// Propose clusters for the residue?
+	if *suggest {
+		if err := o.printSuggestions(clusters); err != nil {
+			return err
+		}
+	}
+
+	// Print the refactoring order?
+	if *order {
+		printOrder(clusters)
+	}
+
+	// Print the cluster DAG as text?
+	if *tree {
+		printTree(clusters)
+	}
+
+	// Print the statistics of each cluster?
+	if *stats {
+		if err := o.computeExports(clusters); err != nil {
+			return err
+		}
+		o.printStats(clusters)
+	}
+
+	// Warn of large SCCs?
+	if *sccWarn > 0 {
+		o.warnLargeSCCs(*sccWarn)
+	}
+
+	// Print the cuts that would break up an SCC?
+	if *sccCuts != "" {
+		if err := o.printSCCCuts(*sccCuts); err != nil {
+			return err
+		}
+	}
+
+	// Print the feedback edges?
+	if *feedback {
+		o.printFeedbackEdges(o.Nodes)
+	}
+
+	// Print the safety report?
+	if *safety {
+		o.printSafetyReport()
+	}
+
+	// Track progress against a previous snapshot?
+	if *progress != "" || *snapshotFile != "" {
+		snap := o.takeSnapshot(clusters)
+		if *progress != "" {
+			old, err := readSnapshot(*progress)
+			if err != nil {
+				return err
 			}
-			sort.Strings(ss)
-			fmt.Printf("= %s\n", c.importPath)
-			for _, s := range ss {
-				fmt.Println(s)
+			printProgress(old, snap)
+		}
+		if *snapshotFile != "" {
+			if err := writeSnapshot(*snapshotFile, snap); err != nil {
+				return err
 			}
-			fmt.Println()
 		}
 	}
 
+	// Print the partition in JSON form?
+	if *jsonOut {
+		if err := o.writeJSON(os.Stdout, clusters); err != nil {
+			return err
+		}
+	}
+
+	// Dump an intermediate representation?
+	if *dump != "" {
+		if err := o.dump(os.Stdout, *dump, clusters); err != nil {
+			return err
+		}
+	}
+
+	// Print a report in another format?
+	switch *outFormat {
+	case "":
+		// none
+	case "matrix":
+		printMatrix(clusters)
+	case "graphml":
+		writeGraphML(os.Stdout, clusters)
+	default:
+		return fmt.Errorf("unknown -format: %q", *outFormat)
+	}
+
 	// Display partition graphically?
 	if *graphdir != "" {
+		switch *expandLevel {
+		case "clusters", "scnodes", "nodes":
+		default:
+			return fmt.Errorf("unknown -expand-level: %q", *expandLevel)
+		}
+		switch *methodEdges {
+		case "all", "real", "both", "none":
+		default:
+			return fmt.Errorf("unknown -show-method-edges: %q", *methodEdges)
+		}
+
 		// Compute the strong component graph to
 		// simplify the displayed output.
-		scgraph := o.makeSCGraph(*fuse)
+		scgraph := o.SCGraph(*fuse)
 
-		if err := renderGraphs(clusters, scgraph); err != nil {
+		if err := o.renderGraphs(clusters, scgraph); err != nil {
 			return err
 		}
 	}
 
-	// Do the refactoring?
-	if *outdir != "" {
-		if err := o.refactor(clusters); err != nil {
-			return err
+	return nil
+}
+
+// printPartition prints the partition to stdout.
+func (o *organizer) printPartition(clusters []*analysis.Cluster) {
+	// Use the same format as the clusters file.
+	fmt.Printf("# Package: %q\n", o.Info.Pkg.Path())
+	fmt.Printf("# Initial cluster file: %q\n", *clusterFile)
+	fmt.Printf("# %d nodes in %d clusters\n", len(o.Nodes), len(clusters))
+	if o.Configs != nil {
+		fmt.Printf("# Configurations: %s\n", strings.Join(o.Configs, ", "))
+	}
+	fmt.Println()
+
+	for _, c := range clusters {
+		var ss []string
+		for n := range c.Nodes {
+			posn := n.Graph.Fset.Position(n.Pos())
+			base := filepath.Base(posn.Filename)
+			// Comment out concrete method nodes since they
+			// follow their receiver types, unless assigned
+			// apart from them, by a stanza with -split-methods
+			// or by a rebinding.
+			var comment string
+			if t := n.RecvNode(); n.IsMethod() && (t == nil || t.Cluster == n.Cluster) {
+				comment = "# "
+			}
+			s := fmt.Sprintf("%s%-40s# %s:%d", comment, n.Name, base, posn.Line)
+			if len(n.Configs) < len(o.Configs) {
+				s += fmt.Sprintf(" [only %s]", strings.Join(n.Configs, ","))
+			}
+			if n.Colocated {
+				s += " [colocated]"
+			}
+			if *docs {
+				if doc := n.Doc(); doc != "" {
+					s += ": " + strings.SplitN(doc, "\n", 2)[0]
+				}
+			}
+			ss = append(ss, s)
+		}
+		sort.Strings(ss)
+		fmt.Printf("= %s\n", c.ImportPath)
+		if len(c.Nodes) > 0 {
+			fmt.Printf("# files: %s\n", clusterFiles(c))
+		}
+		for _, s := range ss {
+			fmt.Println(s)
 		}
+		fmt.Println()
 	}
+	o.printAbsent()
+}
 
-	return nil
+// clusterFiles returns a summary of the source files of the nodes of
+// c, most nodes first, e.g. "alg.go(12), hash.go(3)".
+func clusterFiles(c *analysis.Cluster) string {
+	counts := make(map[string]int)
+	for n := range c.Nodes {
+		counts[n.Filename()]++
+	}
+	files := make([]string, 0, len(counts))
+	for file := range counts {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if x, y := counts[files[i]], counts[files[j]]; x != y {
+			return x > y
+		}
+		return files[i] < files[j]
+	})
+	for i, file := range files {
+		files[i] = fmt.Sprintf("%s(%d)", file, counts[file])
+	}
+	return strings.Join(files, ", ")
 }
@@ -0,0 +1,92 @@
+package main
+
+// This file defines helpers shared by the tests.
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/arl/sockdrawer/analysis"
+	"github.com/arl/sockdrawer/internal/analysistest"
+)
+
+// setFlag sets the named command-line flag for the duration of the
+// test.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	if f == nil {
+		t.Fatalf("no flag -%s", name)
+	}
+	old := f.Value.String()
+	if err := f.Value.Set(value); err != nil {
+		t.Fatalf("-%s=%s: %v", name, value, err)
+	}
+	t.Cleanup(func() { f.Value.Set(old) })
+}
+
+// captureStderr returns what f writes to the standard error.
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	return capture(t, &os.Stderr, f)
+}
+
+// captureStdout returns what f writes to the standard output.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	return capture(t, &os.Stdout, f)
+}
+
+// capture returns what f writes to *file.
+func capture(t *testing.T, file **os.File, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		done <- string(data)
+	}()
+	saved := *file
+	*file = w
+	func() {
+		defer func() {
+			*file = saved
+			w.Close()
+		}()
+		f()
+	}()
+	return <-done
+}
+
+// loadDir loads the package formed by the .go files of dir, as the
+// command does for files named on the command line, and builds its
+// node graph.  The flags that configure the analysis must be set
+// beforehand.
+func loadDir(t *testing.T, dir string) *organizer {
+	t.Helper()
+	return &organizer{Graph: analysistest.Load(t, options(), dir)}
+}
+
+// loadSource loads the package formed by the files, keyed by name,
+// and builds its node graph.
+func loadSource(t *testing.T, files map[string]string) *organizer {
+	t.Helper()
+	return loadDir(t, analysistest.WriteFiles(t, files))
+}
+
+// partitionWith partitions the package of o by the clusters file
+// of the specified content.
+func partitionWith(t *testing.T, o *organizer, clustersFile string) []*analysis.Cluster {
+	t.Helper()
+	setFlag(t, "clusters", analysistest.WriteClusters(t, clustersFile))
+	clusters, err := o.partition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clusters
+}
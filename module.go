@@ -0,0 +1,197 @@
+package main
+
+// This file defines the module-wide view (-module-mode): the import
+// graph of a set of packages and its strongly connected components.
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/arl/sockdrawer/analysis"
+	"golang.org/x/tools/go/buildutil"
+	"golang.org/x/tools/go/loader"
+)
+
+// expandPatterns returns the packages denoted by args, expanding
+// "..." wildcards.  Local patterns such as "./..." are expanded by
+// walking the file tree, since the module-unaware
+// buildutil.ExpandPatterns only searches GOROOT and GOPATH.
+func expandPatterns(args []string) ([]string, error) {
+	var res []string
+	for _, arg := range args {
+		if !strings.HasSuffix(arg, "...") {
+			res = append(res, arg)
+			continue
+		}
+		if !build.IsLocalImport(arg) && !filepath.IsAbs(arg) {
+			pkgs := buildutil.ExpandPatterns(&build.Default, []string{arg})
+			for pkg := range pkgs {
+				res = append(res, pkg)
+			}
+			continue
+		}
+		root := filepath.Clean(strings.TrimSuffix(strings.TrimSuffix(arg, "..."), "/"))
+		err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() {
+				return nil
+			}
+			if base := fi.Name(); path != root &&
+				(base == "testdata" || strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_")) {
+				return filepath.SkipDir
+			}
+			if matches, _ := filepath.Glob(filepath.Join(path, "*.go")); len(matches) > 0 {
+				if !filepath.IsAbs(path) {
+					path = "." + string(filepath.Separator) + path
+				}
+				res = append(res, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// moduleView prints, and with -graphdir renders, the import graph
+// among the initial packages of iprog.  Each package, together with
+// its tests, is a node of the graph, so a cycle (a non-trivial SCC)
+// arises only through the imports of tests.  Such cycles, and more
+// generally all packages of an SCC, are candidates for merging.
+func moduleView(iprog *loader.Program) error {
+	// The graph is built from nodes without syntax,
+	// so that the machinery of SCGraph applies.
+	// Packages are identified by directory, since a package
+	// named on the command line by a relative path such as
+	// "./a" has a different path when imported.
+	o := &organizer{Graph: &analysis.Graph{Fset: iprog.Fset, Options: options()}}
+	dirOf := func(info *loader.PackageInfo) string {
+		if len(info.Files) == 0 {
+			return info.Pkg.Path()
+		}
+		return filepath.Dir(iprog.Fset.Position(info.Files[0].Pos()).Filename)
+	}
+	byDir := make(map[string]*analysis.Node)
+	for _, info := range iprog.InitialPackages() {
+		dir := dirOf(info)
+		if byDir[dir] == nil {
+			n := &analysis.Node{
+				Graph: o.Graph,
+				Name:  strings.TrimSuffix(info.Pkg.Path(), "_test"),
+				Succs: make(map[*analysis.Node]bool),
+				Preds: make(map[*analysis.Node]bool),
+			}
+			byDir[dir] = n
+			o.Nodes = append(o.Nodes, n)
+		}
+	}
+	var nedges int
+	for _, info := range iprog.InitialPackages() {
+		from := byDir[dirOf(info)]
+		for _, imp := range info.Pkg.Imports() {
+			impInfo := iprog.Package(imp.Path())
+			if impInfo == nil {
+				continue
+			}
+			if to := byDir[dirOf(impInfo)]; to != nil && to != from && !from.Succs[to] {
+				to.Name = imp.Path() // prefer the non-local path
+				analysis.AddEdge(from, to)
+				nedges++
+			}
+		}
+	}
+	sort.Slice(o.Nodes, func(i, j int) bool { return o.Nodes[i].Name < o.Nodes[j].Name })
+	for i, n := range o.Nodes {
+		n.ID = i
+	}
+
+	scnodes := o.SCGraph(false)
+	var cycles []*analysis.SCNode
+	for s := range scnodes {
+		if len(s.Nodes) > 1 {
+			cycles = append(cycles, s)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return anyNode(cycles[i]).ID < anyNode(cycles[j]).ID })
+
+	fmt.Printf("# Import graph: %d packages, %d imports, %d SCCs\n", len(o.Nodes), nedges, len(scnodes))
+	for _, n := range o.Nodes {
+		fmt.Println(n.Name)
+		for _, succ := range analysis.SortedNodes(n.Succs) {
+			fmt.Printf("\t-> %s\n", succ.Name)
+		}
+	}
+	if len(cycles) > 0 {
+		fmt.Println("\n# Import cycles (through tests)")
+		for _, s := range cycles {
+			names := make([]string, 0, len(s.Nodes))
+			for _, n := range analysis.SortedNodes(s.Nodes) {
+				names = append(names, n.Name)
+			}
+			fmt.Printf("%s\n", strings.Join(names, " "))
+		}
+	}
+	fmt.Println()
+
+	if *graphdir == "" {
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, "Rendering graphs")
+	if err := os.MkdirAll(*graphdir, 0755); err != nil {
+		return err
+	}
+	if err := writePackages("packages.dot", o.Nodes, cycles); err != nil {
+		return err
+	}
+	if err := runDot("packages.dot", renderedName("packages")); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "\nRun:\n\t%% browser %s\n",
+		filepath.Join(*graphdir, renderedName("packages")))
+	return nil
+}
+
+// writePackages writes to dotfile the import graph of the packages,
+// boxing together the packages of each import cycle.
+func writePackages(dotfile string, nodes []*analysis.Node, cycles []*analysis.SCNode) (err error) {
+	f, err := os.Create(filepath.Join(*graphdir, dotfile))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	fmt.Fprintln(f, "digraph packages {")
+	writeStyle(f)
+	fmt.Fprintln(f, `  edge [arrowhead="open"];`)
+	fmt.Fprintln(f, `  labelloc="t"; label="Import graph";`)
+	fmt.Fprintln(f, `  node [shape="box",style=filled,fillcolor="#e0f0ff"];`)
+	for _, s := range cycles {
+		fmt.Fprintf(f, "  subgraph cluster_%d {\n", s.ID)
+		fmt.Fprintln(f, `    style=filled; fillcolor="#ffe0e0"; label="import cycle";`)
+		for _, n := range analysis.SortedNodes(s.Nodes) {
+			fmt.Fprintf(f, "    p%d;\n", n.ID)
+		}
+		fmt.Fprintln(f, "  }")
+	}
+	for _, n := range nodes {
+		fmt.Fprintf(f, "  p%d [label=%s];\n", n.ID, dotQuote(n.Name))
+		for _, succ := range analysis.SortedNodes(n.Succs) {
+			fmt.Fprintf(f, "  p%d -> p%d;\n", n.ID, succ.ID)
+		}
+	}
+	fmt.Fprintln(f, "}")
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+// This file defines snapshots of the partition's summary statistics,
+// for tracking the progress of a long-running refactoring.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// A snapshot records the summary statistics of one analysis run.
+type snapshot struct {
+	Package    string `json:"package"`
+	Date       string `json:"date"`
+	Nodes      int    `json:"nodes"`
+	Clusters   int    `json:"clusters"` // excluding the residue
+	Residue    int    `json:"residue"`  // nodes in the residue
+	CrossEdges int    `json:"crossEdges"`
+}
+
+// takeSnapshot returns the summary statistics of the partition.
+func (o *organizer) takeSnapshot(clusters []*analysis.Cluster) *snapshot {
+	s := &snapshot{
+		Package: o.Info.Pkg.Path(),
+		Date:    time.Now().Format(time.RFC3339),
+		Nodes:   len(o.Nodes),
+	}
+	for _, c := range clusters {
+		if c.IsResidue() {
+			s.Residue = len(c.Nodes)
+		} else {
+			s.Clusters++
+		}
+	}
+	for _, n := range o.Nodes {
+		for succ := range n.Succs {
+			if succ.Cluster != n.Cluster {
+				s.CrossEdges++
+			}
+		}
+	}
+	return s
+}
+
+// writeSnapshot writes s to the specified file in JSON form.
+func writeSnapshot(filename string, s *snapshot) error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0666)
+}
+
+// readSnapshot reads a snapshot written by writeSnapshot.
+func readSnapshot(filename string) (*snapshot, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return &s, nil
+}
+
+// printProgress prints the change in the summary statistics
+// from the old snapshot to the new one.
+func printProgress(old, new *snapshot) {
+	fmt.Printf("# Progress of package %q since %s\n", new.Package, old.Date)
+	if old.Package != new.Package {
+		fmt.Printf("# (snapshot was of package %q)\n", old.Package)
+	}
+	for _, stat := range []struct {
+		name     string
+		old, new int
+	}{
+		{"nodes", old.Nodes, new.Nodes},
+		{"clusters", old.Clusters, new.Clusters},
+		{"residue nodes", old.Residue, new.Residue},
+		{"cross-cluster edges", old.CrossEdges, new.CrossEdges},
+	} {
+		fmt.Printf("%-20s %6d -> %6d (%+d)\n", stat.name, stat.old, stat.new, stat.new-stat.old)
+	}
+	fmt.Println()
+}
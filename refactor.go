@@ -3,26 +3,17 @@ package main
 // This file defines the refactoring.
 
 // TODO(adonovan): fix:
-// - exported API functions may be moved into internal subpackages,
-//   making them invisible.  We'll need shims/delegates for func and const.
-//   Types and vars are trickier.
-// - use nice import names (e.g. core not _core) when it would be unambiguous to do so.
 // - preserve comments before/in import decls.
 // - look at files for non-linux/amd64 platforms
 // - deal with assembly, compiler entrypoints
-// - check for all conflicts: struct fields, concrete methods, interface methods.
 // - check for definition conflicts at file scope
-// - check for field definition conflicts
-// - check for (abstract and concrete) method definition conflicts
-// - check for renamed package-level types used as embedded fields, etc.
 // - check for reference conflicts (hard)
-// - emit 'git mv' commands so that new files are treated as moves, not adds.
-// - struct literals T{1,2} may need field names T{X:1, Y:2}.
 
 import (
 	"bytes"
 	"fmt"
 	"go/ast"
+	"go/build"
 	"go/format"
 	"go/parser"
 	"go/token"
@@ -31,31 +22,67 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
+	"text/tabwriter"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/arl/sockdrawer/analysis"
 )
 
-func (o *organizer) refactor(clusters []*cluster) error {
-	// new names for objects that must become exported
+// computeExports computes o.exportNames, the new names of the objects
+// that must become exported because they are referenced from other
+// clusters, and marks the nodes that must be exported.
+// It does nothing if they have already been computed.
+func (o *organizer) computeExports(clusters []*analysis.Cluster) error {
+	if o.exportNames != nil {
+		return nil
+	}
+
+	var rules []exportRule
+	if *exportRules != "" {
+		var err error
+		if rules, err = loadExportRules(*exportRules); err != nil {
+			return err
+		}
+	}
+
+	// Give shared variables accessors instead of exporting them?
+	if *varAccessors {
+		o.accessors = o.planAccessors(rules)
+	}
+
 	exportNames := make(map[types.Object]string)
 	export := func(obj types.Object) {
+		if o.accessors[obj] != nil {
+			return
+		}
 		if !ast.IsExported(obj.Name()) {
 			if _, ok := exportNames[obj]; !ok {
-				exportNames[obj] = exportedName(obj.Name())
+				name := applyExportRules(rules, obj.Name())
+				if name != obj.Name() {
+					fmt.Fprintf(os.Stderr, "%s: exporting %s as %s by rule\n",
+						o.Fset.Position(obj.Pos()), obj.Name(), exportedName(name))
+				}
+				exportNames[obj] = exportedName(name)
 			}
 		}
 	}
 
 	// Find objects requiring a name change for export:
 	// the heads of node-graph edges that span clusters.
-	for _, n := range o.nodes {
-		for succ := range n.succs {
-			if n.cluster != succ.cluster {
-				if !succ.mustExport {
-					succ.mustExport = true
-					for _, obj := range succ.objects {
+	// (Tests are not refactored, so their references don't count.)
+	for _, n := range o.Nodes {
+		if n.IsTest() {
+			continue
+		}
+		for _, succ := range analysis.SortedNodes(n.Succs) {
+			if n.Cluster != succ.Cluster {
+				if !o.mustExport[succ] {
+					o.mustExport[succ] = true
+					for _, obj := range succ.Objects {
 						export(obj)
 					}
 				}
@@ -63,52 +90,18 @@ func (o *organizer) refactor(clusters []*cluster) error {
 		}
 	}
 
-	// Fix up package-level definition conflicts in each cluster.
-	for _, c := range clusters {
-		// For now, all import names will be "_" + the last segment.
-		// TODO(adonovan): avoid _ when not needed and make sure
-		// the last segment is a valid identifier.
-		// Alternatively, apply gorename on a file-by-file basis
-		// to eliminate the underscores.
-
-		c.name = "_" + path.Base(c.importPath) // (default)
-		c.scope = make(map[string]*node)
-		for n := range c.nodes {
-			for _, obj := range n.objects {
-				if !isPackageLevel(obj) {
-					continue
-				}
-				// NB: only exported symbols may conflict.
-				// That may change when we deal with imports.
-				name := obj.Name()
-				if new, ok := exportNames[obj]; ok {
-					name = new
-				}
-				if prev := c.scope[name]; prev != nil {
-					fmt.Fprintf(os.Stderr, "%s: warning: exporting %s\n",
-						o.fset.Position(n.syntax.Pos()),
-						obj.Name())
-					fmt.Fprintf(os.Stderr, "%s: \twould conflict with %s; adding 'X' prefix.\n",
-						o.fset.Position(prev.syntax.Pos()), name)
-
-					// TODO(adonovan): fix: use a unique prefix
-					// that never appears in the package!
-					name = "X" + name
-					exportNames[obj] = name
-				}
-				c.scope[name] = n
-			}
-		}
-	}
-
 	// Mark selectables (fields and methods) for export if they
 	// are ever referenced from outside their defining package.
 	// TODO(adonovan): fix: must compute consequences (a la gorename).
-	for _, n := range o.nodes {
-		for _, obj := range n.uses {
+	for _, n := range o.Nodes {
+		if n.IsTest() {
+			continue
+		}
+		for _, id := range n.SortedUses() {
+			obj := n.Uses[id]
 			if v, ok := obj.(*types.Var); ok && v.IsField() {
 				// field
-			} else if f, ok := obj.(*types.Func); ok && methodRecv(f) != nil {
+			} else if f, ok := obj.(*types.Func); ok && analysis.MethodRecv(f) != nil {
 				// method
 			} else {
 				continue
@@ -116,41 +109,181 @@ func (o *organizer) refactor(clusters []*cluster) error {
 			// obj is a field or method
 
 			// inter-cluster reference?
-			if o.nodesByObj[obj].cluster != n.cluster {
+			if o.NodesByObj[obj].Cluster != n.Cluster {
 				export(obj)
 			}
 		}
+
+		// A selection x.f may implicitly traverse a chain of
+		// embedded fields, each of which must be exported too
+		// if defined in another cluster.
+		ast.Inspect(n.Syntax, func(syntax ast.Node) bool {
+			// An unkeyed struct literal T{1, 2} implicitly
+			// refers to all the fields of T.
+			if lit, ok := syntax.(*ast.CompositeLit); ok {
+				if st := o.unkeyedStruct(n, lit); st != nil {
+					for i := 0; i < st.NumFields(); i++ {
+						if field := st.Field(i); field.Name() != "_" {
+							export(field)
+						}
+					}
+				}
+			}
+			if sel, ok := syntax.(*ast.SelectorExpr); ok {
+				chain := embeddedChain(o.Info.Selections[sel])
+				var crosses bool
+				for _, field := range chain {
+					if n2 := o.NodesByObj[field]; n2 != nil && n2.Cluster != n.Cluster {
+						crosses = true
+						export(field)
+						// An embedded field is named after its type.
+						if tn := embeddedTypeName(field); tn != nil {
+							export(tn)
+							if new, ok := exportNames[tn]; ok {
+								exportNames[field] = new
+							}
+						}
+					}
+				}
+				if crosses {
+					names := make([]string, 0, len(chain)+1)
+					for _, field := range chain {
+						names = append(names, field.Name())
+					}
+					names = append(names, sel.Sel.Name)
+					fmt.Fprintf(os.Stderr, "%s: note: selection traverses embedded fields %s\n",
+						o.Fset.Position(sel.Sel.Pos()), strings.Join(names, "."))
+				}
+			}
+			return true
+		})
+	}
+
+	// An embedded field is named after its type, so if either
+	// is exported, both must be, and by the same new name.
+	// (Its new name is set after the conflicts are fixed, below.)
+	embedded := o.embeddedFields()
+	for _, field := range embedded {
+		if _, ok := exportNames[field]; ok {
+			export(embeddedTypeName(field))
+		}
+	}
+
+	// Fix up package-level definition conflicts in each cluster,
+	// by a prefix that begins no name of the package.  The objects
+	// keeping their names claim them first, so that only renamed
+	// ones acquire the prefix.
+	prefix := o.uniquePrefix()
+	taken := o.fileNames()
+	refs := o.crossRefs()
+	for _, c := range clusters {
+		o.clusterNames[c] = o.importName(c, taken, refs[c])
+		o.scopes[c] = make(map[string]*analysis.Node)
+		imports := o.clusterImports(c)
+		for _, renamed := range []bool{false, true} {
+			for _, n := range analysis.SortedNodes(c.Nodes) {
+				for _, obj := range n.Objects {
+					if !analysis.IsPackageLevel(obj) {
+						continue
+					}
+					// NB: only exported symbols may conflict,
+					// with each other or with the names of the
+					// imports of the cluster's files.
+					name, ok := exportNames[obj]
+					if ok != renamed {
+						continue
+					}
+					if !ok {
+						name = obj.Name()
+					}
+					var prev token.Pos
+					if n2 := o.scopes[c][name]; n2 != nil {
+						prev = n2.Syntax.Pos()
+					} else if imp := imports[name]; imp != nil && renamed {
+						prev = imp.Pos()
+					}
+					if prev.IsValid() {
+						fmt.Fprintf(os.Stderr, "%s: warning: exporting %s\n",
+							o.Fset.Position(n.Syntax.Pos()),
+							obj.Name())
+						fmt.Fprintf(os.Stderr, "%s: \twould conflict with %s; adding %q prefix.\n",
+							o.Fset.Position(prev), name, prefix)
+						name = prefix + name
+						exportNames[obj] = name
+					}
+					o.scopes[c][name] = n
+				}
+			}
+		}
 	}
 
+	for _, field := range embedded {
+		if new, ok := exportNames[embeddedTypeName(field)]; ok {
+			exportNames[field] = new
+		}
+	}
+
+	// Fix up field definition conflicts in each struct.
+	o.checkFieldConflicts(exportNames, prefix)
+
+	// Warn about conflicts among the renamed methods.
+	o.checkMethodConflicts(exportNames, prefix)
+
+	// Warn about renamings that reflection may observe.
+	o.warnReflectiveRenames(exportNames)
+
+	o.exportNames = exportNames
+	return nil
+}
+
+func (o *organizer) refactor(clusters []*analysis.Cluster) error {
+	if err := o.computeExports(clusters); err != nil {
+		return err
+	}
+	exportNames := o.exportNames
+
 	// Inspect referring identifiers within each node.
 	// Compute import dependencies (existing and new packages).
 	// Qualify inter-cluster references with the new package name.
-	for _, n := range o.nodes {
-		for id, obj := range n.uses {
+	// Tests are omitted from the output, so they are left alone.
+	var ntests int
+	specs := o.importSpecs()
+	for _, n := range o.Nodes {
+		if n.IsTest() {
+			ntests++
+			continue
+		}
+		for id, obj := range n.Uses {
 			// existing import dependency?
+			// (This includes uses of dot-imported names.)
 			if pkgName, ok := obj.(*types.PkgName); ok {
-				n.addImport(pkgName)
+				o.addImport(n, specs[pkgName])
 				continue
 			}
 
+			// Cross-cluster use of a variable with accessors?
+			// (It is replaced by a call, below.)
+			if acc := o.accessors[obj]; acc != nil {
+				if n2 := o.NodesByObj[obj]; n2.Cluster != n.Cluster {
+					o.addImport(n, n2.Cluster)
+					continue
+				}
+			}
+
 			name := id.Name
 			if new, ok := exportNames[obj]; ok {
 				name = new
 			}
 
 			// Cross-package reference to package-level entity?
-			//
-			// TODO(adonovan): fix: check the lexical
-			// structure to see if the name is free.  If
-			// not, uniquify n2.cluster.name.  For now,
-			// globally qualify; later, uniquify it only as
-			// needed on a per-cluster basis.
-			if isPackageLevel(obj) {
-				n2 := o.nodesByObj[obj]
-				if n2.cluster != n.cluster {
+			// (The import name of its cluster is free here;
+			// see importName.)
+			if analysis.IsPackageLevel(obj) {
+				n2 := o.NodesByObj[obj]
+				if n2.Cluster != n.Cluster {
 					// qualify the identifier
-					name = n2.cluster.name + "." + name
-					n.addImport(n2.cluster)
+					name = o.clusterNames[n2.Cluster] + "." + name
+					o.addImport(n, n2.Cluster)
 
 				}
 			}
@@ -159,46 +292,132 @@ func (o *organizer) refactor(clusters []*cluster) error {
 		}
 	}
 
+	// Give field names to unkeyed literals of other clusters' struct
+	// types, T{1, 2} becoming T{X: 1, Y: 2}, since the fields are
+	// renamed and a literal of a struct type with unexported fields
+	// (such as _) can't be unkeyed outside its package.
+	for _, n := range o.Nodes {
+		if !n.IsTest() {
+			o.addLiteralKeys(n)
+		}
+	}
+
+	// Replace cross-cluster uses of variables by accessor calls.
+	if len(o.accessors) > 0 {
+		for _, n := range o.Nodes {
+			if !n.IsTest() {
+				o.rewriteAccessors(n)
+			}
+		}
+	}
+
 	// Modify defining identifiers for exported objects.
-	for id, obj := range o.info.Defs {
-		if new, ok := exportNames[obj]; ok {
+	// (The identifier of an embedded field is also a use of its
+	// type, already renamed, and perhaps qualified, above.)
+	for id, obj := range o.Info.Defs {
+		if new, ok := exportNames[obj]; ok && o.Info.Uses[id] == nil {
 			id.Name = new
 		}
 	}
 
 	// Split the source files into files in subpackages.
+	o.grouping = o.selectedGrouping(clusters)
 	if err := o.split(); err != nil {
 		return err
 	}
+	o.writeAccessors()
+	if *shims {
+		o.writeShims(clusters)
+	}
+
+	if ntests > 0 {
+		fmt.Fprintf(os.Stderr, "note: omitting %d test declarations from the output\n", ntests)
+	}
+
+	// Only show what would be written?
+	if *dryRun {
+		o.printPlan(clusters)
+		return nil
+	}
 
-	// Now write the clusters out:
+	// Now write the clusters out, leaving unchanged files alone.
 	var failed bool
+	written := make(map[string]bool) // output files, relative to outdir
 	fmt.Fprintf(os.Stderr, "Writing refactored output...\n")
 	for _, c := range clusters {
-		dir := filepath.Join(*outdir, c.importPath)
+		dir := filepath.Join(*outdir, c.ImportPath)
 		fmt.Fprintf(os.Stderr, "\t%s", dir)
+		var unchanged []string
+		record := func(base string, changed bool, err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, ": %v", err)
+				failed = true
+				return
+			}
+			written[filepath.Join(c.ImportPath, base)] = true
+			if !changed {
+				unchanged = append(unchanged, base)
+			}
+		}
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			fmt.Fprintf(os.Stderr, ": %v", err)
 			failed = true
 		} else {
-			// Create an empty .s file in each new package;
+			// Create an empty .s file in each new package
+			// that declares functions without bodies;
 			// this causes gc to suppress "missing function
 			// body" errors until link time.
-			ioutil.WriteFile(filepath.Join(dir, "dummy.s"), nil, 0666)
+			if *asmStub && hasBodilessFuncs(c) {
+				changed, err := writeIfChanged(filepath.Join(dir, "asm_stub.s"), nil)
+				record("asm_stub.s", changed, err)
+			}
 
-			for base, out := range c.outputFiles {
-				filename := filepath.Join(dir, base)
-				if err := out.writeFile(filename); err != nil {
-					fmt.Fprintf(os.Stderr, ": %v", err)
-					failed = true
-				}
+			for base, out := range o.outputFiles[c] {
+				changed, err := o.writeFile(out, filepath.Join(dir, base))
+				record(base, changed, err)
 			}
+
+			// Give each new package a test target.
+			if base, src := o.testStub(c); base != "" {
+				changed, err := writeIfChanged(filepath.Join(dir, base), src)
+				record(base, changed, err)
+			}
+		}
+		if len(unchanged) > 0 {
+			sort.Strings(unchanged)
+			fmt.Fprintf(os.Stderr, " (unchanged: %s)", strings.Join(unchanged, ", "))
 		}
 		fmt.Fprintln(os.Stderr)
 	}
 	if failed {
 		return fmt.Errorf("there were I/O errors")
 	}
+	if *gitmv {
+		written["gitmv.sh"] = true
+	}
+
+	// Remove the files of the previous output that this one lacks?
+	if *clean {
+		if err := removeStale(written); err != nil {
+			return err
+		}
+	}
+	if err := writeManifest(written); err != nil {
+		return err
+	}
+
+	// Record the splits as renames?
+	if *gitmv {
+		if err := o.writeGitMv(clusters); err != nil {
+			return err
+		}
+	}
+
+	// Check that the output compiles?
+	if *verify {
+		srcDir := filepath.Dir(o.Fset.Position(o.Info.Files[0].Pos()).Filename)
+		return verifyOutput(srcDir, clusters)
+	}
 	return nil
 }
 
@@ -218,17 +437,17 @@ func (o *organizer) split() error {
 	// text of each one to the appropriate node's text.
 	//
 	// We do this one file at a time, splitting the pretty text into
-	// declarations, with order determined by forEachDecl again, for
-	// consistency.  This way each decl corresponds to o.nodes[i].
+	// declarations, with order determined by analysis.ForEachDecl again, for
+	// consistency.  This way each decl corresponds to o.Nodes[i].
 	//
 	var i int // node index
-	for _, f := range o.info.Files {
-		filename := o.fset.Position(f.Pos()).Filename
+	for _, f := range o.Info.Files {
+		filename := o.Fset.Position(f.Pos()).Filename
 		filebase := filepath.Base(filename)
 
 		// Print each file and parse it back.
 		var buf bytes.Buffer
-		if err := format.Node(&buf, o.fset, f); err != nil {
+		if err := format.Node(&buf, o.Fset, f); err != nil {
 			return fmt.Errorf("pretty-printing %s failed: %v", filename, err)
 		}
 
@@ -262,27 +481,42 @@ func (o *organizer) split() error {
 		offset = withNewline(text, offset)
 
 		var enterGroupText []byte // current group's opening whitespace and "var ("
+		var last *outputFile      // output file of the last decl of f
 
 		// Map parsed pretty decls back to their corresponding nodes.
-		forEachDecl(f2, func(syntax ast.Node, parent *ast.GenDecl) {
+		analysis.ForEachDecl(f2, func(syntax ast.Node, parent *ast.GenDecl) {
 			// Find node and cluster corresponding to syntax.
 			// (Careful: methods have no node of their own,
-			// so we can't use o.nodes[i].)
-			n := o.nodes[i]
+			// so we can't use o.Nodes[i].)
+			n := o.Nodes[i]
 			i++
-			out := n.cluster.file(filebase)
-			out.addImportsFor(n)
+			if n.IsTest() {
+				return // tests are not refactored
+			}
+			base := o.grouping(n.Cluster, f, filename)
+			out := o.file(n.Cluster, base)
+			out.addImports(o.nodeImports[n])
+			last = out
+			out.nodes++
 
 			// first time writing to this file?
 			if out.head.Len() == 0 {
-				out.head.Write(initialComment)
+				if *provenance {
+					from := path.Join(o.Info.Pkg.Path(), filebase)
+					if base != filebase {
+						from = "package " + o.Info.Pkg.Path()
+					}
+					out.head.Write(withProvenance(initialComment, from))
+				} else {
+					out.head.Write(initialComment)
+				}
 				// TODO(adonovan): fix: think about the
 				// leading \n.  Is it sound w.r.t. both
 				// package documentation (which doesn't
 				// want it) and +build comments (which
 				// need it)?
 				fmt.Fprintf(&out.head, "package %s\n\n",
-					path.Base(n.cluster.importPath))
+					analysis.PackageName(n.Cluster.ImportPath))
 			}
 
 			// Handle transitions into/out of group decls:
@@ -320,11 +554,7 @@ func (o *organizer) split() error {
 				}
 			}
 			// The final implicit "leaving group" transition for
-			// each file is handled by (*cluster).writeFile.
-
-			// TODO(adonovan): fix: don't discard comments
-			// at the end of each file; copy them to all
-			// output files.
+			// each file is handled by writeFile.
 
 			// Emit node syntax.
 			// Emit in all text since the end of the last decl.
@@ -341,13 +571,278 @@ func (o *organizer) split() error {
 				offset = rparen
 			}
 		})
+
+		// Attach the comments at the end of the file, after the
+		// last decl, to the output file of that decl, so that
+		// they are not duplicated.
+		if trailing := bytes.TrimSpace(text[offset:]); len(trailing) > 0 && last != nil {
+			if last.groupDecl != nil {
+				last.body.WriteString(")\n")
+				last.groupDecl = nil
+			}
+			fmt.Fprintf(&last.body, "\n%s\n", trailing)
+		}
 	}
-	if i != len(o.nodes) {
+	for i < len(o.Nodes) && o.Nodes[i].XTest {
+		i++ // the external test package is not refactored
+	}
+	if i != len(o.Nodes) {
 		panic("internal error")
 	}
 	return nil
 }
 
+// unkeyedStruct returns the struct type of the composite literal if
+// it has unkeyed elements and its type is declared by a cluster other
+// than n's; otherwise it returns nil.
+func (o *organizer) unkeyedStruct(n *analysis.Node, lit *ast.CompositeLit) *types.Struct {
+	if len(lit.Elts) == 0 {
+		return nil
+	}
+	if _, ok := lit.Elts[0].(*ast.KeyValueExpr); ok {
+		return nil
+	}
+	T := o.Info.TypeOf(lit)
+	if ptr, ok := T.(*types.Pointer); ok {
+		T = ptr.Elem() // &T{...} elided in an outer literal
+	}
+	named, ok := T.(*types.Named)
+	if !ok {
+		return nil
+	}
+	// The fields of the generic type, not of its instantiation,
+	// are those renamed.
+	st, ok := named.Origin().Underlying().(*types.Struct)
+	if !ok {
+		return nil
+	}
+	if n2 := o.NodesByObj[named.Obj()]; n2 == nil || n2.Cluster == n.Cluster {
+		return nil
+	}
+	return st
+}
+
+// addLiteralKeys adds the (new) field names to each unkeyed literal
+// within n of a struct type declared by another cluster, including
+// nested literals.  It warns about literals with blank fields, which
+// can't be keyed.
+func (o *organizer) addLiteralKeys(n *analysis.Node) {
+	ast.Inspect(n.Syntax, func(syntax ast.Node) bool {
+		lit, ok := syntax.(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		st := o.unkeyedStruct(n, lit)
+		if st == nil {
+			return true
+		}
+		for i := 0; i < st.NumFields(); i++ {
+			if st.Field(i).Name() == "_" {
+				fmt.Fprintf(os.Stderr, "%s: warning: can't add field names to this literal: its type has blank fields\n",
+					o.Fset.Position(lit.Lbrace))
+				return true
+			}
+		}
+		for i, elt := range lit.Elts {
+			field := st.Field(i)
+			name := field.Name()
+			if new, ok := o.exportNames[field]; ok {
+				name = new
+			}
+			lit.Elts[i] = &ast.KeyValueExpr{
+				Key:   &ast.Ident{NamePos: elt.Pos(), Name: name},
+				Colon: elt.Pos(),
+				Value: elt,
+			}
+		}
+		return true
+	})
+}
+
+// embeddedChain returns the embedded fields implicitly traversed by
+// the selection, in order, or nil if there are none.
+func embeddedChain(sel *types.Selection) []*types.Var {
+	if sel == nil {
+		return nil
+	}
+	var chain []*types.Var
+	T := sel.Recv()
+	index := sel.Index()
+	for _, i := range index[:len(index)-1] {
+		if ptr, ok := T.(*types.Pointer); ok {
+			T = ptr.Elem()
+		}
+		st, ok := T.Underlying().(*types.Struct)
+		if !ok {
+			break // e.g. embedded interface
+		}
+		field := st.Field(i)
+		chain = append(chain, analysis.OriginObj(field).(*types.Var))
+		T = field.Type()
+	}
+	return chain
+}
+
+// embeddedTypeName returns the package-level named type of the
+// embedded field, or nil if it has none.
+func embeddedTypeName(field *types.Var) *types.TypeName {
+	T := field.Type()
+	if ptr, ok := T.(*types.Pointer); ok {
+		T = ptr.Elem()
+	}
+	if named, ok := T.(*types.Named); ok && analysis.IsPackageLevel(named.Obj()) {
+		return named.Obj()
+	}
+	return nil
+}
+
+// clusterImports returns the existing imports used by the
+// declarations of cluster c, by name.
+func (o *organizer) clusterImports(c *analysis.Cluster) map[string]*types.PkgName {
+	imports := make(map[string]*types.PkgName)
+	for _, n := range analysis.SortedNodes(c.Nodes) {
+		if n.IsTest() {
+			continue // tests are not refactored
+		}
+		for _, id := range n.SortedUses() {
+			if pkgName, ok := n.Uses[id].(*types.PkgName); ok && imports[pkgName.Name()] == nil {
+				imports[pkgName.Name()] = pkgName
+			}
+		}
+	}
+	return imports
+}
+
+// embeddedFields returns the embedded fields of the package whose
+// types are named by it, in order of position.
+func (o *organizer) embeddedFields() []*types.Var {
+	var fields []*types.Var
+	for _, obj := range o.Info.Defs {
+		if field, ok := obj.(*types.Var); ok && field.Embedded() {
+			if tn := embeddedTypeName(field); tn != nil && tn.Pkg() == o.Info.Pkg {
+				fields = append(fields, field)
+			}
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Pos() < fields[j].Pos() })
+	return fields
+}
+
+// printPlan prints the files that refactor would write, and the
+// number of nodes in each; files with none, such as accessors and
+// shims, are generated.
+func (o *organizer) printPlan(clusters []*analysis.Cluster) {
+	fmt.Println("# Files that would be written")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	planned := make(map[string]bool) // relative to outdir
+	for _, c := range clusters {
+		dir := filepath.Join(*outdir, c.ImportPath)
+		var bases []string
+		for base := range o.outputFiles[c] {
+			bases = append(bases, base)
+		}
+		sort.Strings(bases)
+		for _, base := range bases {
+			what := "generated"
+			if n := o.outputFiles[c][base].nodes; n > 0 {
+				what = fmt.Sprintf("%d nodes", n)
+			}
+			fmt.Fprintf(w, "%s\t%s\n", filepath.Join(dir, base), what)
+			planned[filepath.Join(c.ImportPath, base)] = true
+		}
+		if *asmStub && hasBodilessFuncs(c) {
+			fmt.Fprintf(w, "%s\t%s\n", filepath.Join(dir, "asm_stub.s"), "empty")
+			planned[filepath.Join(c.ImportPath, "asm_stub.s")] = true
+		}
+		if base, _ := o.testStub(c); base != "" {
+			fmt.Fprintf(w, "%s\t%s\n", filepath.Join(dir, base), "test stub")
+			planned[filepath.Join(c.ImportPath, base)] = true
+		}
+	}
+	if *gitmv {
+		fmt.Fprintf(w, "%s\t%s\n", filepath.Join(*outdir, "gitmv.sh"), "script")
+		planned["gitmv.sh"] = true
+	}
+	if *clean {
+		stale, err := staleFiles(planned)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sockdrawer: %v\n", err)
+		}
+		for _, file := range stale {
+			fmt.Fprintf(w, "%s\t%s\n", filepath.Join(*outdir, file), "stale; would be removed")
+		}
+	}
+	w.Flush()
+	fmt.Println()
+}
+
+// testStub returns the base name and content of the placeholder test
+// file of c (-gen-test-stubs), or "" if c gets none: it is the
+// residue, or already has an output file of that name.
+func (o *organizer) testStub(c *analysis.Cluster) (string, []byte) {
+	name := analysis.PackageName(c.ImportPath)
+	base := name + "_test.go"
+	if !*genTestStubs || c.IsResidue() || o.outputFiles[c][base] != nil {
+		return "", nil
+	}
+	src := fmt.Sprintf("package %s\n\nimport \"testing\"\n\nfunc Test%s(t *testing.T) {\n\t// TODO: test package %s.\n}\n",
+		name, exportedName(name), c.ImportPath)
+	return base, []byte(src)
+}
+
+// writeIfChanged writes data to the file, unless it already holds
+// exactly that, so that an unchanged file keeps its modification time
+// and causes no rebuilds.  It reports whether it wrote the file.
+func writeIfChanged(filename string, data []byte) (bool, error) {
+	if old, err := ioutil.ReadFile(filename); err == nil && bytes.Equal(old, data) {
+		return false, nil
+	}
+	return true, ioutil.WriteFile(filename, data, 0666)
+}
+
+// hasBodilessFuncs reports whether c contains a function
+// declared without a body, such as one implemented in assembly.
+func hasBodilessFuncs(c *analysis.Cluster) bool {
+	for n := range c.Nodes {
+		if decl, ok := n.Syntax.(*ast.FuncDecl); ok && decl.Body == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// withProvenance returns the initial comment of an output file
+// derived from the specified source (a file, or the whole package),
+// with a comment describing its origin inserted after any build
+// constraints.
+func withProvenance(initialComment []byte, from string) []byte {
+	// Find the end of the leading build constraints, if any.
+	var end int
+	for offset := 0; offset < len(initialComment); {
+		eol := withNewline(initialComment, offset)
+		line := strings.TrimSpace(string(initialComment[offset:eol]))
+		if strings.HasPrefix(line, "//go:build") || strings.HasPrefix(line, "// +build") {
+			end = eol
+		} else if line != "" {
+			break
+		}
+		offset = eol
+	}
+
+	var buf bytes.Buffer
+	buf.Write(initialComment[:end])
+	if end > 0 {
+		buf.WriteString("\n")
+	}
+	fmt.Fprintf(&buf, "// This file was generated by sockdrawer from %s", from)
+	if *clusterFile != "" {
+		fmt.Fprintf(&buf, " using clusters file %s", *clusterFile)
+	}
+	buf.WriteString(".\n\n")
+	buf.Write(initialComment[end:])
+	return buf.Bytes()
+}
+
 func withNewline(data []byte, i int) int {
 	for ; i < len(data); i++ {
 		if data[i] == '\n' {
@@ -357,73 +852,253 @@ func withNewline(data []byte, i int) int {
 	return i
 }
 
-func (n *node) addImport(imp interface{}) {
-	if n.imports == nil {
-		n.imports = make(map[interface{}]bool)
+// An importSpec is an import declaration of the package, as written:
+// name is its explicit name, if any, such as an alias or ".", and
+// local the name by which the file refers to the package.
+type importSpec struct{ name, path, local string }
+
+// importSpecs returns the import declaration of each imported
+// package name of the package.
+func (o *organizer) importSpecs() map[*types.PkgName]importSpec {
+	specs := make(map[*types.PkgName]importSpec)
+	for _, f := range o.Info.Files {
+		for _, imp := range f.Imports {
+			var obj types.Object
+			var name string
+			if imp.Name != nil {
+				obj, name = o.Info.Defs[imp.Name], imp.Name.Name
+			} else {
+				obj = o.Info.Implicits[imp]
+			}
+			if pkgName, ok := obj.(*types.PkgName); ok {
+				specs[pkgName] = importSpec{name, pkgName.Imported().Path(), pkgName.Name()}
+			}
+		}
+	}
+	return specs
+}
+
+// addImport records imp, an importSpec or a *Cluster,
+// as a dependency of n.
+func (o *organizer) addImport(n *analysis.Node, imp interface{}) {
+	if o.nodeImports[n] == nil {
+		o.nodeImports[n] = make(map[interface{}]bool)
 	}
-	n.imports[imp] = true
+	o.nodeImports[n][imp] = true
 }
 
 // outputFile holds state for each output file.
 type outputFile struct {
 	head, body bytes.Buffer         // head is package decl + cluster imports
-	imports    map[interface{}]bool // union of node.imports
+	imports    map[interface{}]bool // union of the imports of its nodes
 	groupDecl  ast.Decl             // previous group decl, if any
+	nodes      int                  // number of nodes written to the file
 }
 
-func (out *outputFile) addImportsFor(n *node) {
+func (out *outputFile) addImports(imports map[interface{}]bool) {
 	if out.imports == nil {
 		out.imports = make(map[interface{}]bool)
 	}
-	for imp := range n.imports {
+	for imp := range imports {
 		out.imports[imp] = true
 	}
 }
 
-func (c *cluster) file(base string) *outputFile {
-	f := c.outputFiles[base]
+// file returns the output file of cluster c of the specified base
+// name, creating it if necessary.
+func (o *organizer) file(c *analysis.Cluster, base string) *outputFile {
+	f := o.outputFiles[c][base]
 	if f == nil {
+		if o.outputFiles[c] == nil {
+			o.outputFiles[c] = make(map[string]*outputFile)
+		}
 		f = new(outputFile)
-		c.outputFiles[base] = f
+		o.outputFiles[c][base] = f
 	}
 	return f
 }
 
-// writeFile writes the outputFile data to the specified file.
-func (out *outputFile) writeFile(filename string) error {
-	// Add necessary imports to head.
-	if len(out.imports) > 0 {
-		var importLines []string
-		for imp := range out.imports {
-			var name, importPath string
-			switch imp := imp.(type) {
-			case *types.PkgName:
-				name = imp.Name()
-				importPath = imp.Imported().Path()
-			case *cluster:
-				name = imp.name
-				importPath = imp.importPath
-			}
-			var spec string
-			if name == path.Base(importPath) {
-				spec = fmt.Sprintf("\t%q\n", importPath)
-			} else {
-				spec = fmt.Sprintf("\t%s %q\n", name, importPath)
+// A fileGrouping returns the base name of the file of cluster c to
+// which the declarations of source file f (named filename) are
+// written.
+type fileGrouping func(c *analysis.Cluster, f *ast.File, filename string) string
+
+// selectedGrouping returns the fileGrouping chosen by the flags.
+// With -coalesce, the clusters whose declarations can't share a file
+// (see coalesceConflict) still get one file per source file.
+func (o *organizer) selectedGrouping(clusters []*analysis.Cluster) fileGrouping {
+	if !*coalesce {
+		return perSourceFile
+	}
+	separate := make(map[*analysis.Cluster]bool)
+	for _, c := range clusters {
+		if pos, why := o.coalesceConflict(c); why != "" {
+			fmt.Fprintf(os.Stderr, "%s: warning: not coalescing %s: %s; writing a file per source file\n",
+				o.Fset.Position(pos), c.ImportPath, why)
+			separate[c] = true
+		}
+	}
+	return func(c *analysis.Cluster, f *ast.File, filename string) string {
+		if separate[c] {
+			return perSourceFile(c, f, filename)
+		}
+		return perCluster(c, f, filename)
+	}
+}
+
+// coalesceConflict returns the position of an import that prevents
+// the declarations of cluster c drawn from several source files from
+// sharing a file, and why, or "" if there is none: two imports of the
+// same name but different packages, an import of the name of a
+// declaration of c, or a dot import, which would apply to the
+// declarations of the other files too.
+func (o *organizer) coalesceConflict(c *analysis.Cluster) (token.Pos, string) {
+	files := make(map[string]*ast.File)
+	for _, f := range o.Info.Files {
+		files[o.Fset.Position(f.Pos()).Filename] = f
+	}
+	imports := make(map[string]*types.PkgName) // by name
+	for _, n := range analysis.SortedNodes(c.Nodes) {
+		filename := o.Fset.Position(n.Syntax.Pos()).Filename
+		if n.IsTest() || isConstrained(files[filename], filename) {
+			continue // not coalesced
+		}
+		for _, id := range n.SortedUses() {
+			pkgName, ok := n.Uses[id].(*types.PkgName)
+			if !ok {
+				continue
 			}
-			importLines = append(importLines, spec)
+			name, path := pkgName.Name(), pkgName.Imported().Path()
+			if name == "." {
+				return pkgName.Pos(), fmt.Sprintf("its dot import of %q would apply to all its declarations", path)
+			}
+			if prev := imports[name]; prev != nil && prev.Imported() != pkgName.Imported() {
+				return pkgName.Pos(), fmt.Sprintf("it imports both %q and %q as %s", prev.Imported().Path(), path, name)
+			}
+			if o.scopes[c][name] != nil {
+				return pkgName.Pos(), fmt.Sprintf("its import of %q as %s conflicts with a declaration", path, name)
+			}
+			imports[name] = pkgName
 		}
-		sort.Strings(importLines)
-		fmt.Fprintf(&out.head, "import (\n")
-		for _, imp := range importLines {
-			out.head.WriteString(imp)
+	}
+	return token.NoPos, ""
+}
+
+// perSourceFile is the default fileGrouping: each cluster has one
+// output file per source file from which it draws declarations, of
+// the same name.
+func perSourceFile(c *analysis.Cluster, f *ast.File, filename string) string {
+	return filepath.Base(filename)
+}
+
+// perCluster is the -coalesce fileGrouping: each cluster has a single
+// output file named after its package, except that a source file
+// built only in some configurations keeps a file of its own.
+func perCluster(c *analysis.Cluster, f *ast.File, filename string) string {
+	if isConstrained(f, filename) {
+		return filepath.Base(filename)
+	}
+	return analysis.PackageName(c.ImportPath) + ".go"
+}
+
+// isConstrained reports whether the source file f (named filename)
+// is built only in some configurations: it has build constraints, a
+// GOOS or GOARCH suffix, or uses cgo.
+func isConstrained(f *ast.File, filename string) bool {
+	for _, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if strings.HasPrefix(c.Text, "//go:build") || strings.HasPrefix(c.Text, "// +build") {
+				return true
+			}
 		}
-		fmt.Fprintf(&out.head, ")\n")
 	}
+	// No file name matches an unknown platform, unless it names no
+	// platform.  Nor does a cgo file, with cgo disabled.
+	ctxt := build.Default
+	ctxt.GOOS, ctxt.GOARCH, ctxt.CgoEnabled = "none", "none", false
+	ok, err := ctxt.MatchFile(filepath.Dir(filename), filepath.Base(filename))
+	return err != nil || !ok
+}
 
+// qualifiers returns the set of names used as the qualifier x of a
+// selector x.f in the body of the file, such as the names of the
+// packages to which it refers, or nil if the body can't be parsed.
+func (out *outputFile) qualifiers() map[string]bool {
+	src := append([]byte("package p\n"), out.body.Bytes()...)
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil // gofmt will report it
+	}
+	used := make(map[string]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok {
+				used[x.Name] = true
+			}
+		}
+		return true
+	})
+	return used
+}
+
+// importLine returns the line of an import declaration of the
+// specified path, naming it only if the name is not empty and differs
+// from the implicit one.
+func importLine(name, importPath, implicit string) string {
+	if name == "" || name == implicit {
+		return fmt.Sprintf("\t%q\n", importPath)
+	}
+	return fmt.Sprintf("\t%s %q\n", name, importPath)
+}
+
+// writeFile writes the data of out to the specified file, unless
+// it is unchanged, and reports whether it wrote it.
+func (o *organizer) writeFile(out *outputFile, filename string) (bool, error) {
 	// Implement final state transition.
 	if out.groupDecl != nil {
 		// leaving var or type(...) decl
 		out.body.WriteString(")\n")
+		out.groupDecl = nil
+	}
+
+	// Add necessary imports to head: those the rewritten
+	// declarations of the file actually refer to.
+	used := out.qualifiers()
+	var importLines []string
+	seen := make(map[string]bool)
+	for imp := range out.imports {
+		var spec, local string
+		switch imp := imp.(type) {
+		case importSpec:
+			// Reproduce the original declaration.
+			spec = importLine(imp.name, imp.path, "")
+			local = imp.local
+		case *types.Package:
+			spec = importLine(imp.Name(), imp.Path(), path.Base(imp.Path()))
+			local = imp.Name()
+		case *analysis.Cluster:
+			spec = importLine(o.clusterNames[imp], imp.ImportPath, analysis.PackageName(imp.ImportPath))
+			local = o.clusterNames[imp]
+		}
+		if used != nil && !used[local] && local != "." && local != "_" {
+			logf(1, "%s: dropping unused import %s\n", filename, strings.TrimSpace(spec))
+			continue
+		}
+		if !seen[spec] {
+			seen[spec] = true // e.g. an import of several source files
+			importLines = append(importLines, spec)
+		}
+	}
+	if len(importLines) > 0 {
+		sort.Strings(importLines)
+		fmt.Fprintf(&out.head, "import (\n")
+		for _, imp := range importLines {
+			out.head.WriteString(imp)
+		}
+		fmt.Fprintf(&out.head, ")\n")
 	}
 
 	// Write formatted head and data to filename.
@@ -433,10 +1108,180 @@ func (out *outputFile) writeFile(filename string) error {
 	// Run it through gofmt.
 	data, err := format.Source(data)
 	if err != nil {
-		return fmt.Errorf("failed to gofmt %s: %v", filename, err)
+		return false, fmt.Errorf("failed to gofmt %s: %v", filename, err)
 	}
 
-	return ioutil.WriteFile(filename, data, 0666)
+	return writeIfChanged(filename, data)
+}
+
+// An exportRule rewrites the names of objects that become exported,
+// before the default capitalization applies.
+type exportRule struct {
+	pattern     *regexp.Regexp // matches the entire name
+	replacement string         // may refer to submatches: $1, etc
+}
+
+// loadExportRules loads the export rules from the specified file.
+// Each line holds a regular expression and its replacement,
+// separated by space, e.g. "http(.*)  HTTP$1".
+// Blank lines and comments starting with '#' are ignored.
+func loadExportRules(filename string) ([]exportRule, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rules []exportRule
+	for i, line := range strings.Split(string(data), "\n") {
+		if j := strings.IndexByte(line, '#'); j >= 0 {
+			line = line[:j] // strip comments
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue // skip blanks
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want 'pattern replacement'", filename, i+1)
+		}
+		re, err := regexp.Compile("^(?:" + fields[0] + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, i+1, err)
+		}
+		rules = append(rules, exportRule{re, fields[1]})
+	}
+	return rules, nil
+}
+
+// applyExportRules returns name rewritten by the first matching rule,
+// or name itself if none matches.
+func applyExportRules(rules []exportRule, name string) string {
+	for _, rule := range rules {
+		if rule.pattern.MatchString(name) {
+			return rule.pattern.ReplaceAllString(name, rule.replacement)
+		}
+	}
+	return name
+}
+
+// fileNames returns the set of names with which the import name of
+// a cluster must not collide, regardless of where it is referenced:
+// those of package-level objects and imported packages, and the
+// predeclared names used by the package, which an import would shadow
+// throughout the file.
+func (o *organizer) fileNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, name := range o.Info.Pkg.Scope().Names() {
+		names[name] = true
+	}
+	for _, obj := range o.Info.Implicits {
+		if _, ok := obj.(*types.PkgName); ok {
+			names[obj.Name()] = true
+		}
+	}
+	for _, obj := range o.Info.Defs {
+		if _, ok := obj.(*types.PkgName); ok {
+			names[obj.Name()] = true
+		}
+	}
+	for _, obj := range o.Info.Uses {
+		if obj.Parent() == types.Universe {
+			names[obj.Name()] = true
+		}
+	}
+	return names
+}
+
+// crossRefs returns the identifiers that refer to package-level
+// objects of other clusters, and will thus be qualified by the import
+// names of those clusters, grouped by cluster.
+func (o *organizer) crossRefs() map[*analysis.Cluster][]*ast.Ident {
+	refs := make(map[*analysis.Cluster][]*ast.Ident)
+	for _, n := range o.Nodes {
+		if n.IsTest() {
+			continue
+		}
+		for id, obj := range n.Uses {
+			if analysis.IsPackageLevel(obj) {
+				if n2 := o.NodesByObj[obj]; n2 != nil && n2.Cluster != n.Cluster {
+					refs[n2.Cluster] = append(refs[n2.Cluster], id)
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// shadowed reports whether a local declaration of the name is in
+// scope at any of the identifiers.
+func (o *organizer) shadowed(name string, ids []*ast.Ident) bool {
+	pkgScope := o.Info.Pkg.Scope()
+	for _, id := range ids {
+		scope := pkgScope.Innermost(id.Pos())
+		if scope == nil {
+			continue
+		}
+		if _, obj := scope.LookupParent(name, id.Pos()); obj != nil &&
+			obj.Parent() != pkgScope && obj.Parent() != types.Universe {
+			return true
+		}
+	}
+	return false
+}
+
+// uniquePrefix returns the shortest of X, Y, Z, X_, X__, etc, with
+// which no name defined or used by the package begins, nor the
+// exported form of such a name, so that prefixing an exported name
+// with it never creates a conflict.
+func (o *organizer) uniquePrefix() string {
+	var names []string
+	add := func(id *ast.Ident) {
+		if strings.Trim(id.Name, "_") != "" {
+			names = append(names, id.Name, exportedName(id.Name))
+		}
+	}
+	for id := range o.Info.Defs {
+		add(id)
+	}
+	for id := range o.Info.Uses {
+		add(id)
+	}
+	begins := func(prefix string) bool {
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, prefix := range []string{"X", "Y", "Z"} {
+		if !begins(prefix) {
+			return prefix
+		}
+	}
+	prefix := "X_"
+	for begins(prefix) {
+		prefix += "_"
+	}
+	return prefix
+}
+
+// importName returns the name by which the cluster c is imported:
+// its package name, if it is not in taken, and no local declaration shadows it at the references
+// to c; or otherwise the same with an underscore prefix, and a numeric
+// suffix if needed.  It adds the result to taken.
+func (o *organizer) importName(c *analysis.Cluster, taken map[string]bool, refs []*ast.Ident) string {
+	base := analysis.PackageName(c.ImportPath)
+	free := func(name string) bool {
+		return token.IsIdentifier(name) && !taken[name] && !o.shadowed(name, refs)
+	}
+	name := base
+	for i := 1; !free(name); i++ {
+		name = "_" + base
+		if i > 1 {
+			name += fmt.Sprint(i)
+		}
+	}
+	taken[name] = true
+	return name
 }
 
 // exportName returns the corresponding exported name for a non-exported identifier.
@@ -458,7 +1303,3 @@ func exportedName(name string) string {
 }
 
 // -- from refactor/rename --
-
-func isPackageLevel(obj types.Object) bool {
-	return obj.Pkg().Scope().Lookup(obj.Name()) == obj
-}
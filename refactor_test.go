@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files of TestGolden")
+
+// TestGolden splits each package of testdata/golden and compares the
+// output with the golden files.  Each case is a directory holding:
+//
+//	in/*.go		the package to split
+//	clusters	its clusters file
+//	flags		optional extra flags, one "name=value" per line
+//	out/		the expected output, by import path
+//
+// The output must also compile, unless the case directory holds a
+// file named nobuild, whose content explains why not.
+// Run "go test -run=Golden -update" to regenerate the golden files.
+func TestGolden(t *testing.T) {
+	cases, err := filepath.Glob(filepath.Join("testdata", "golden", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatal("no golden cases")
+	}
+	for _, dir := range cases {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			testGolden(t, dir)
+		})
+	}
+}
+
+func testGolden(t *testing.T, dir string) {
+	// Copy the input out of this module, so that -verify
+	// builds the output as an ordinary GOPATH package.
+	in := t.TempDir()
+	inputs, err := filepath.Glob(filepath.Join(dir, "in", "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range inputs {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(in, filepath.Base(filename)), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "flags")); err == nil {
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			if eq := strings.Index(line, "="); eq > 0 {
+				setFlag(t, line[:eq], line[eq+1:])
+			} else if line != "" {
+				setFlag(t, line, "true")
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	outdir := t.TempDir()
+	setFlag(t, "outdir", outdir)
+	_, err = os.Stat(filepath.Join(dir, "nobuild"))
+	setFlag(t, "verify", strconv.FormatBool(os.IsNotExist(err)))
+
+	o := loadDir(t, in)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "clusters"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters := partitionWith(t, o, string(data))
+	if err := o.refactor(clusters); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readTree(t, outdir)
+	golden := filepath.Join(dir, "out")
+	if *update {
+		if err := os.RemoveAll(golden); err != nil {
+			t.Fatal(err)
+		}
+		for file, data := range got {
+			filename := filepath.Join(golden, file)
+			if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filename, data, 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return
+	}
+	want := readTree(t, golden)
+	for file, data := range want {
+		if got[file] == nil {
+			t.Errorf("missing output file %s", file)
+		} else if !bytes.Equal(got[file], data) {
+			t.Errorf("output file %s differs from golden file:\n%s", file, got[file])
+		}
+	}
+	for file := range got {
+		if want[file] == nil {
+			t.Errorf("unexpected output file %s:\n%s", file, got[file])
+		}
+	}
+}
+
+// readTree returns the content of each file within dir, by its
+// slash-separated path relative to dir, except the manifest.
+func readTree(t *testing.T, dir string) map[string][]byte {
+	files := make(map[string][]byte)
+	err := filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() == manifestName {
+			return err
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filename)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// References to universe objects, such as error, len and the Error
+// method of error, require no exports, even when the package shadows
+// other predeclared names: only the package's own objects used from
+// other clusters are exported.
+func TestUniverseExports(t *testing.T) {
+	o := loadSource(t, map[string]string{"p.go": `package p
+
+var cap = 3 // shadows the builtin
+
+func f(s []int) error {
+	if len(s) > cap {
+		return nil
+	}
+	var err error
+	_ = err.Error()
+	return err
+}
+
+func g() string { return f(nil).Error() }
+`})
+	clusters := partitionWith(t, o, "= p/low\nf\n")
+	if err := o.computeExports(clusters); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for obj, name := range o.exportNames {
+		names = append(names, obj.Name()+"="+name)
+	}
+	sort.Strings(names)
+	if got, want := strings.Join(names, " "), "f=F"; got != want {
+		t.Errorf("exportNames = %s, want %s", got, want)
+	}
+}
+
+// The methods and fields of a generic type used from another cluster
+// are exported, even if used through an instantiation.
+func TestGenericMethodExports(t *testing.T) {
+	o := loadSource(t, map[string]string{"p.go": `package p
+
+type List[T any] struct{ x []T }
+
+func (l *List[T]) Push(v T) { l.x = append(l.x, v) }
+
+func (l *List[T]) len() int { return len(l.x) }
+
+func use() int {
+	var l List[int]
+	l.Push(1)
+	return l.len() + len(l.x)
+}
+`})
+	clusters := partitionWith(t, o, "= p/list\nList\n")
+	if err := o.computeExports(clusters); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for obj, name := range o.exportNames {
+		names = append(names, obj.Name()+"="+name)
+	}
+	sort.Strings(names)
+	if got, want := strings.Join(names, " "), "len=Len x=X"; got != want {
+		t.Errorf("exportNames = %s, want %s", got, want)
+	}
+}
+
+// The provenance comment follows any build constraints, and records
+// only the origin of the file, so that regenerating unchanged output
+// changes nothing.
+func TestWithProvenance(t *testing.T) {
+	setFlag(t, "clusters", "p.clusters")
+	for _, test := range []struct {
+		initialComment, want string
+	}{
+		{"", "// This file was generated by sockdrawer from p.go using clusters file p.clusters.\n\n"},
+		{
+			"//go:build linux\n\n// Package p.\n",
+			"//go:build linux\n\n" +
+				"// This file was generated by sockdrawer from p.go using clusters file p.clusters.\n\n" +
+				"\n// Package p.\n",
+		},
+	} {
+		if got := string(withProvenance([]byte(test.initialComment), "p.go")); got != test.want {
+			t.Errorf("withProvenance(%q) = %q, want %q", test.initialComment, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+// This file defines the read-eval-print loop (-repl), which keeps the
+// type-checked package and its node graph resident so that the
+// clusters file can be edited and reloaded cheaply.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+const replHelp = `Commands:
+  r, reload	Reload the clusters file and emit the output selected by the flags.
+  p, print	Print the partition.
+  m, matrix	Print the coupling matrix.
+  g, graphs	Render the graphs (requires -graphdir).
+  q, quit	Quit.
+`
+
+// repl reads commands from the standard input until EOF or "quit".
+// clusters is the current partition.
+func (o *organizer) repl(clusters []*analysis.Cluster) error {
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprint(os.Stderr, "sockdrawer> ")
+		if !in.Scan() {
+			fmt.Fprintln(os.Stderr)
+			return in.Err()
+		}
+		var err error
+		switch cmd := strings.TrimSpace(in.Text()); cmd {
+		case "":
+			// no-op
+		case "r", "reload":
+			var c []*analysis.Cluster
+			if c, err = o.reload(); c != nil {
+				clusters = c
+			}
+		case "p", "print":
+			o.printPartition(clusters)
+		case "m", "matrix":
+			printMatrix(clusters)
+		case "g", "graphs":
+			if *graphdir == "" {
+				err = fmt.Errorf("no -graphdir")
+			} else {
+				err = o.renderGraphs(clusters, o.SCGraph(*fuse))
+			}
+		case "q", "quit":
+			return nil
+		case "h", "help", "?":
+			fmt.Fprint(os.Stderr, replHelp)
+		default:
+			err = fmt.Errorf("unknown command %q; try help", cmd)
+		}
+		if err != nil {
+			// Errors in the clusters file are not fatal.
+			fmt.Fprintf(os.Stderr, "sockdrawer: %s\n", err)
+		}
+	}
+}
+
+// reload reloads the clusters file, and emits the output selected by
+// the flags for the new partition, which it returns, if it could be
+// computed, even if the output fails.
+func (o *organizer) reload() ([]*analysis.Cluster, error) {
+	clusters, err := o.partition()
+	if err != nil {
+		return nil, err
+	}
+	failure := o.checkPartition(clusters)
+	if err := o.display(clusters); err != nil {
+		return clusters, err
+	}
+	printSummary(o.Nodes, clusters)
+	return clusters, failure
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// A reload applies the checks of a fresh run: it rejects a clusters
+// file whose clusters depend on each other cyclically.
+func TestReloadCycle(t *testing.T) {
+	o := loadSource(t, map[string]string{"p.go": `package main
+
+func main() { a() }
+
+func a() { b() }
+
+func b() {}
+
+var restart = main
+`})
+	partitionWith(t, o, "= p/low\nb\n")
+	var err error
+	captureStderr(t, func() { _, err = o.reload() })
+	if err != nil {
+		t.Fatalf("reload of acyclic clusters file: %v", err)
+	}
+
+	// restart refers to main, which stays in the residue, which in
+	// turn refers to b.
+	partitionWith(t, o, "= p/low\nb\nrestart\n")
+	stderr := captureStderr(t, func() { _, err = o.reload() })
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("reload of cyclic clusters file: got error %v, want a cycle", err)
+	}
+	if !strings.Contains(stderr, "the cluster graph is cyclic") {
+		t.Errorf("reload of cyclic clusters file reported:\n%s\nwant the cycle", stderr)
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+// This file defines textual reports on the partition.
+
+import (
+	"fmt"
+	"go/types"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// printMatrix prints the coupling matrix of the partition: cell
+// [i][j] is the number of node-graph edges from cluster i to
+// cluster j.  The diagonal holds the number of internal edges.
+func printMatrix(clusters []*analysis.Cluster) {
+	counts := make([][]int, len(clusters))
+	index := make(map[*analysis.Cluster]int)
+	for i, c := range clusters {
+		counts[i] = make([]int, len(clusters))
+		index[c] = i
+	}
+	for i, c := range clusters {
+		for n := range c.Nodes {
+			for succ := range n.Succs {
+				counts[i][index[succ.Cluster]]++
+			}
+		}
+	}
+
+	fmt.Println("# Coupling matrix: number of node-graph edges from row to column cluster")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "\t\t")
+	for i := range clusters {
+		fmt.Fprintf(w, "%d\t", i)
+	}
+	fmt.Fprintln(w)
+	for i, c := range clusters {
+		fmt.Fprintf(w, "%d\t%s\t", i, c.ImportPath)
+		for _, count := range counts[i] {
+			fmt.Fprintf(w, "%d\t", count)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
+
+// printTree prints the cluster DAG as text, lowest cluster first,
+// each line indented by the cluster's depth (the length of the
+// longest dependency path below it) and listing the clusters it
+// depends on directly.  Dependencies on later clusters, which make
+// the graph cyclic, are marked as upward.
+func printTree(clusters []*analysis.Cluster) {
+	index := make(map[*analysis.Cluster]int)
+	for i, c := range clusters {
+		index[c] = i
+	}
+	depth := make([]int, len(clusters))
+	fmt.Println("# Cluster DAG, lowest first; indentation shows dependency depth")
+	for i, c := range clusters {
+		succs := make(map[*analysis.Cluster]bool)
+		for n := range c.Nodes {
+			for succ := range n.Succs {
+				if succ.Cluster != c {
+					succs[succ.Cluster] = true
+				}
+			}
+		}
+		var deps []string
+		for _, succ := range analysis.SortedClusters(succs) {
+			j := index[succ]
+			if j > i {
+				deps = append(deps, succ.ImportPath+" (upward)")
+				continue
+			}
+			if depth[j]+1 > depth[i] {
+				depth[i] = depth[j] + 1
+			}
+			deps = append(deps, succ.ImportPath)
+		}
+		what := fmt.Sprintf("%d nodes", len(c.Nodes))
+		if c.IsResidue() {
+			what += "; residue"
+		}
+		fmt.Printf("%s%s (%s)", strings.Repeat("  ", depth[i]), c.ImportPath, what)
+		if len(deps) > 0 {
+			fmt.Printf(" -> %s", strings.Join(deps, ", "))
+		}
+		fmt.Println()
+	}
+	fmt.Println()
+}
+
+// printOrder prints the order in which the clusters should be split
+// off into subpackages: each after all the clusters it depends on,
+// otherwise in the order of the clusters file.  A cluster that depends
+// on the residue can't be split off until those references are broken,
+// so it is flagged, as are clusters whose dependencies form a cycle.
+func printOrder(clusters []*analysis.Cluster) {
+	deps := make(map[*analysis.Cluster]map[*analysis.Cluster]int) // edges to other clusters, by count
+	for _, c := range clusters {
+		deps[c] = make(map[*analysis.Cluster]int)
+		for n := range c.Nodes {
+			if n.IsTest() {
+				continue // tests are not refactored
+			}
+			for succ := range n.Succs {
+				if succ.Cluster != c {
+					deps[c][succ.Cluster]++
+				}
+			}
+		}
+	}
+
+	fmt.Println("# Split off the clusters into subpackages in this order")
+	done := make(map[*analysis.Cluster]bool)
+	var step int
+	for progress := true; progress; {
+		progress = false
+		for _, c := range clusters {
+			if done[c] || c.IsResidue() {
+				continue
+			}
+			ready := true
+			for d := range deps[c] {
+				if !done[d] && !d.IsResidue() {
+					ready = false
+					break
+				}
+			}
+			if !ready {
+				continue
+			}
+			done[c] = true
+			progress = true
+			step++
+			fmt.Printf("%d. %s (%d nodes)\n", step, c.ImportPath, len(c.Nodes))
+			for d, count := range deps[c] {
+				if d.IsResidue() {
+					fmt.Printf("\twarning: depends on the residue (%d references)\n", count)
+				}
+			}
+			break // restart, to prefer earlier clusters
+		}
+	}
+	for _, c := range clusters {
+		if !done[c] && !c.IsResidue() {
+			fmt.Printf("-. %s (%d nodes)\n\twarning: in a dependency cycle\n", c.ImportPath, len(c.Nodes))
+		}
+	}
+	fmt.Println()
+}
+
+// printFileReport prints, for each cluster, the source files whose
+// nodes all belong to that cluster ("clean" files, which may be moved
+// wholesale) and those whose nodes are split across several clusters
+// ("fragmented" files, which must be split).
+func printFileReport(nodes []*analysis.Node, clusters []*analysis.Cluster) {
+	total := make(map[string]int)                            // nodes per file
+	perCluster := make(map[*analysis.Cluster]map[string]int) // nodes per file per cluster
+	for _, n := range nodes {
+		file := n.Filename()
+		total[file]++
+		if perCluster[n.Cluster] == nil {
+			perCluster[n.Cluster] = make(map[string]int)
+		}
+		perCluster[n.Cluster][file]++
+	}
+
+	fmt.Println("# Source files of each cluster")
+	for _, c := range clusters {
+		files := make([]string, 0, len(perCluster[c]))
+		for file := range perCluster[c] {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+
+		fmt.Printf("= %s\n", c.ImportPath)
+		for _, file := range files {
+			if count := perCluster[c][file]; count == total[file] {
+				fmt.Printf("\tclean      %s (%d nodes)\n", file, count)
+			}
+		}
+		for _, file := range files {
+			if count := perCluster[c][file]; count < total[file] {
+				fmt.Printf("\tfragmented %s (%d of %d nodes)\n", file, count, total[file])
+			}
+		}
+	}
+	fmt.Println()
+}
+
+// printAPISurface prints, for each cluster, the symbols it must
+// export because other clusters refer to them: the API of the
+// proposed subpackage.  Symbols are shown by their original
+// declarations, noting the new names of those that must be renamed.
+func (o *organizer) printAPISurface(clusters []*analysis.Cluster) {
+	surface := make(map[*analysis.Cluster]map[types.Object]bool)
+	for _, n := range o.Nodes {
+		if n.IsTest() {
+			continue // tests are not refactored
+		}
+		for _, obj := range n.Uses {
+			n2 := o.NodesByObj[obj]
+			if n2 == nil || n2.Cluster == n.Cluster {
+				continue
+			}
+			if surface[n2.Cluster] == nil {
+				surface[n2.Cluster] = make(map[types.Object]bool)
+			}
+			surface[n2.Cluster][obj] = true
+		}
+	}
+
+	qualifier := types.RelativeTo(o.Info.Pkg)
+	fmt.Println("# API surface of each cluster")
+	for _, c := range clusters {
+		var lines []string
+		for obj := range surface[c] {
+			line := types.ObjectString(obj, qualifier)
+			if _, ok := obj.(*types.TypeName); ok {
+				line = "type " + obj.Name() // omit the underlying type
+			}
+			if new, ok := o.exportNames[obj]; ok {
+				line += "\t// exported as " + new
+			}
+			lines = append(lines, line)
+		}
+		sort.Strings(lines)
+
+		fmt.Printf("= %s (%d symbols)\n", c.ImportPath, len(lines))
+		for _, line := range lines {
+			fmt.Printf("\t%s\n", line)
+		}
+	}
+	fmt.Println()
+}
+
+// printExportReport prints the unexported identifiers that the split
+// forces to be exported (per exportNames), grouped by the pair of
+// clusters whose references require it: user, then definer.  Those
+// that no direct reference from another cluster requires, such as
+// embedded fields renamed along with their types, are listed last.
+// It must be called after computeExports.
+func (o *organizer) printExportReport(clusters []*analysis.Cluster) {
+	type pair struct{ user, definer *analysis.Cluster }
+	byPair := make(map[pair]map[types.Object]bool)
+	attributed := make(map[types.Object]bool)
+	for _, n := range o.Nodes {
+		if n.IsTest() {
+			continue // tests are not refactored
+		}
+		for _, obj := range n.Uses {
+			def := o.NodesByObj[obj]
+			if _, ok := o.exportNames[obj]; !ok || def == nil || def.Cluster == n.Cluster {
+				continue
+			}
+			p := pair{n.Cluster, def.Cluster}
+			if byPair[p] == nil {
+				byPair[p] = make(map[types.Object]bool)
+			}
+			byPair[p][obj] = true
+			attributed[obj] = true
+		}
+	}
+	var indirect []types.Object
+	for obj := range o.exportNames {
+		if !attributed[obj] {
+			indirect = append(indirect, obj)
+		}
+	}
+
+	// describe returns the lines for a set of objects, in lexical order.
+	describe := func(objs []types.Object) []string {
+		sort.Slice(objs, func(i, j int) bool { return objs[i].Pos() < objs[j].Pos() })
+		var lines []string
+		for _, obj := range objs {
+			name := obj.Name()
+			if def := o.NodesByObj[obj]; def != nil && !analysis.IsPackageLevel(obj) && def.Recv == nil {
+				name = def.Name + "." + name // a field
+			} else if def != nil && def.Recv != nil {
+				name = def.Name // a method, e.g. (*T).f
+			}
+			lines = append(lines, fmt.Sprintf("\t%-40s exported as %s", name, o.exportNames[obj]))
+		}
+		return lines
+	}
+
+	fmt.Printf("# Identifiers exported because of the split: %d\n", len(o.exportNames))
+	for _, user := range clusters {
+		for _, definer := range clusters {
+			objs := byPair[pair{user, definer}]
+			if len(objs) == 0 {
+				continue
+			}
+			list := make([]types.Object, 0, len(objs))
+			for obj := range objs {
+				list = append(list, obj)
+			}
+			fmt.Printf("= %s -> %s (%d identifiers)\n", user.ImportPath, definer.ImportPath, len(list))
+			for _, line := range describe(list) {
+				fmt.Println(line)
+			}
+		}
+	}
+	if len(indirect) > 0 {
+		fmt.Printf("= exported indirectly (%d identifiers)\n", len(indirect))
+		for _, line := range describe(indirect) {
+			fmt.Println(line)
+		}
+	}
+	fmt.Println()
+}
+
+// printByFile prints, for each source file, the clusters among which
+// its nodes are distributed, and how many nodes each receives.
+func printByFile(nodes []*analysis.Node, clusters []*analysis.Cluster) {
+	counts := make(map[string]map[*analysis.Cluster]int) // nodes per cluster per file
+	for _, n := range nodes {
+		file := n.Filename()
+		if counts[file] == nil {
+			counts[file] = make(map[*analysis.Cluster]int)
+		}
+		counts[file][n.Cluster]++
+	}
+	files := make([]string, 0, len(counts))
+	for file := range counts {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	fmt.Println("# Clusters of each source file")
+	for _, file := range files {
+		fmt.Printf("%s (%d clusters)\n", file, len(counts[file]))
+		for _, c := range clusters {
+			if count := counts[file][c]; count > 0 {
+				fmt.Printf("\t%-40s %d nodes\n", c.ImportPath, count)
+			}
+		}
+	}
+	fmt.Println()
+}
+
+// printStats prints, for each cluster, its numbers of nodes and
+// scnodes, the source lines its nodes span, the number of its nodes
+// that must be exported, and the numbers of node-graph edges into it
+// from other clusters and out of it to them.
+// It must be called after computeExports.
+func (o *organizer) printStats(clusters []*analysis.Cluster) {
+	type stats struct{ nodes, scnodes, lines, exported, in, out int }
+	perCluster := make(map[*analysis.Cluster]*stats)
+	for _, c := range clusters {
+		perCluster[c] = new(stats)
+	}
+	for s := range o.SCGraph(false) {
+		perCluster[s.Cluster].scnodes++
+	}
+	for _, n := range o.Nodes {
+		st := perCluster[n.Cluster]
+		st.nodes++
+		start := o.Fset.Position(n.Pos()).Line
+		end := o.Fset.Position(n.End()).Line
+		st.lines += end - start + 1
+		if o.mustExport[n] {
+			st.exported++
+		}
+		for succ := range n.Succs {
+			if succ.Cluster != n.Cluster {
+				st.out++
+				perCluster[succ.Cluster].in++
+			}
+		}
+	}
+
+	fmt.Println("# Cluster statistics")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "cluster\tnodes\tscnodes\tlines\texported\tedges in\tedges out\t")
+	for _, c := range clusters {
+		st := perCluster[c]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t\n",
+			c.ImportPath, st.nodes, st.scnodes, st.lines, st.exported, st.in, st.out)
+	}
+	w.Flush()
+	fmt.Println()
+}
+
+// printSummary prints to stderr a one-line summary of the partition:
+// the numbers of nodes, of those remaining in the residue, and of
+// clusters other than the residue, and the percentage of the nodes
+// partitioned.  The work is done when the residue is empty.
+func printSummary(nodes []*analysis.Node, clusters []*analysis.Cluster) {
+	var remaining, defined int
+	for _, c := range clusters {
+		if c.IsResidue() {
+			remaining = len(c.Nodes)
+		} else {
+			defined++
+		}
+	}
+	percent := 100
+	if len(nodes) > 0 {
+		percent = 100 * (len(nodes) - remaining) / len(nodes)
+	}
+	fmt.Fprintf(os.Stderr, "summary: %d nodes, %d in the residue, %d clusters; %d%% partitioned\n",
+		len(nodes), remaining, defined, percent)
+}
+
+// maxCutCandidates is the number of nodes named by -scc-warn.
+const maxCutCandidates = 5
+
+// warnLargeSCCs warns of each SCC of the node graph of more than max
+// nodes, naming as candidates for cutting its first few nodes in
+// order of exportedness and in-degree, since the SCC is most likely
+// held together by the references to them.
+func (o *organizer) warnLargeSCCs(max int) {
+	for _, s := range analysis.SortedSCNodes(o.SCGraph(false)) {
+		if len(s.Nodes) <= max {
+			continue
+		}
+		order := analysis.SortedNodes(s.Nodes)
+		sort.Sort(analysis.ByExportednessAndInDegree(order))
+		if len(order) > maxCutCandidates {
+			order = order[:maxCutCandidates]
+		}
+		var names []string
+		for _, n := range order {
+			names = append(names, fmt.Sprintf("%s (%d preds)", n.Name, len(n.Preds)))
+		}
+		fmt.Fprintf(os.Stderr, "%s: warning: strongly connected component of %d nodes, more than %d\n",
+			o.Fset.Position(anyNode(s).Pos()), len(s.Nodes), max)
+		fmt.Fprintf(os.Stderr, "\tlikely cut candidates: %s\n", strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// The API surface of a cluster omits the symbols referred to only
+// by tests, which are not refactored.
+func TestAPISurface(t *testing.T) {
+	o := loadSource(t, map[string]string{
+		"p.go": `package p
+
+func f() {}
+
+func g() {}
+
+func h() { f() }
+`,
+		"p_test.go": `package p
+
+import "testing"
+
+func TestG(t *testing.T) { g() }
+`,
+	})
+	clusters := partitionWith(t, o, "= p/low\nf\ng\n")
+	got := captureStdout(t, func() { o.printAPISurface(clusters) })
+	want := "= p/low (1 symbols)\n\tfunc f()\n"
+	if !strings.Contains(got, want) {
+		t.Errorf("printAPISurface printed:\n%s\nwant:\n%s", got, want)
+	}
+}
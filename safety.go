@@ -0,0 +1,199 @@
+package main
+
+// This file defines the safety report, a checklist of the features of
+// the package that the refactoring does not fully handle.
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// A risk is a kind of construct that needs manual review
+// after refactoring, along with its occurrences.
+type risk struct {
+	title, advice string
+	posns         []token.Pos
+}
+
+// printSafetyReport prints to stdout the risks present in the
+// package under the current partition.
+func (o *organizer) printSafetyReport() {
+	cgo := &risk{
+		title:  "cgo",
+		advice: "the split packages each need their own preamble and C declarations",
+	}
+	dotImports := &risk{
+		title:  "dot imports",
+		advice: "unqualified references may be resolved by the wrong package after the split",
+	}
+	reflection := &risk{
+		title:  "reflection by name",
+		advice: "fields and methods looked up by name may be renamed by export",
+	}
+	anonymous := &risk{
+		title:  "fields and methods of anonymous types selected across clusters",
+		advice: "exporting them changes the identity of the anonymous type, breaking assignability from structurally identical types",
+	}
+	literals := &risk{
+		title:  "positional composite literals of cross-cluster types",
+		advice: "fields of the literal's type may be renamed or unexported in its new package",
+	}
+
+	var dir string
+	for _, f := range o.Info.Files {
+		dir = filepath.Dir(o.Fset.Position(f.Pos()).Filename)
+		for _, imp := range f.Imports {
+			if path, _ := strconv.Unquote(imp.Path.Value); path == "C" {
+				cgo.posns = append(cgo.posns, imp.Pos())
+			}
+			if imp.Name != nil && imp.Name.Name == "." {
+				dotImports.posns = append(dotImports.posns, imp.Pos())
+			}
+		}
+	}
+
+	for _, n := range o.Nodes {
+		ast.Inspect(n.Syntax, func(syntax ast.Node) bool {
+			switch syntax := syntax.(type) {
+			case *ast.SelectorExpr:
+				if sel := o.Info.Selections[syntax]; sel != nil && len(sel.Index()) == 1 {
+					recv := sel.Recv()
+					if ptr, ok := recv.Underlying().(*types.Pointer); ok {
+						recv = ptr.Elem()
+					}
+					if _, ok := recv.(*types.Named); !ok {
+						// x.f where x is of an anonymous struct
+						// or interface type; is f defined elsewhere?
+						if n2 := o.NodesByObj[sel.Obj()]; n2 != nil && n2.Cluster != n.Cluster {
+							anonymous.posns = append(anonymous.posns, syntax.Sel.Pos())
+						}
+					}
+				}
+				switch syntax.Sel.Name {
+				case "FieldByName", "MethodByName":
+					if obj := o.Info.Uses[syntax.Sel]; obj != nil &&
+						obj.Pkg() != nil && obj.Pkg().Path() == "reflect" {
+						reflection.posns = append(reflection.posns, syntax.Sel.Pos())
+					}
+				}
+
+			case *ast.CompositeLit:
+				if len(syntax.Elts) == 0 {
+					break
+				}
+				if _, ok := syntax.Elts[0].(*ast.KeyValueExpr); ok {
+					break
+				}
+				T, ok := o.Info.TypeOf(syntax).(*types.Named)
+				if !ok {
+					break
+				}
+				if _, ok := T.Underlying().(*types.Struct); !ok {
+					break
+				}
+				if n2 := o.NodesByObj[T.Obj()]; n2 != nil && n2.Cluster != n.Cluster {
+					literals.posns = append(literals.posns, syntax.Pos())
+				}
+			}
+			return true
+		})
+	}
+
+	fmt.Printf("# Safety report for package %q\n", o.Info.Pkg.Path())
+	var nrisks int
+	for _, r := range []*risk{cgo, dotImports, reflection, anonymous, literals} {
+		if len(r.posns) == 0 {
+			continue
+		}
+		nrisks++
+		fmt.Printf("- %s (%d): %s\n", r.title, len(r.posns), r.advice)
+		for _, pos := range r.posns {
+			fmt.Printf("\t%s\n", o.Fset.Position(pos))
+		}
+	}
+	if asm, _ := filepath.Glob(filepath.Join(dir, "*.s")); len(asm) > 0 {
+		nrisks++
+		fmt.Printf("- assembly (%d files): functions implemented in assembly "+
+			"must be moved along with their Go declarations\n", len(asm))
+		for _, file := range asm {
+			fmt.Printf("\t%s\n", file)
+		}
+	}
+	if nrisks == 0 {
+		fmt.Println("No known risks.")
+	}
+	fmt.Println()
+}
+
+// warnReflectiveRenames warns about fields and methods that the
+// refactoring would rename (per exportNames) but whose names matter
+// at run time: fields with struct tags, whose serialization by
+// packages such as encoding/json changes when they become exported,
+// and fields and methods whose names appear as string literals in
+// calls to reflect's FieldByName or MethodByName.
+func (o *organizer) warnReflectiveRenames(exportNames map[types.Object]string) {
+	tags := make(map[types.Object]*ast.BasicLit)
+	lookups := make(map[string][]token.Pos) // names looked up reflectively
+	for _, f := range o.Info.Files {
+		ast.Inspect(f, func(syntax ast.Node) bool {
+			switch syntax := syntax.(type) {
+			case *ast.StructType:
+				for _, field := range syntax.Fields.List {
+					if field.Tag == nil {
+						continue
+					}
+					for _, id := range field.Names {
+						if obj := o.Info.Defs[id]; obj != nil {
+							tags[obj] = field.Tag
+						}
+					}
+				}
+
+			case *ast.CallExpr:
+				sel, ok := syntax.Fun.(*ast.SelectorExpr)
+				if !ok || len(syntax.Args) != 1 {
+					break
+				}
+				if sel.Sel.Name != "FieldByName" && sel.Sel.Name != "MethodByName" {
+					break
+				}
+				if obj := o.Info.Uses[sel.Sel]; obj == nil || obj.Pkg() == nil || obj.Pkg().Path() != "reflect" {
+					break
+				}
+				if lit, ok := syntax.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					if name, err := strconv.Unquote(lit.Value); err == nil {
+						lookups[name] = append(lookups[name], lit.Pos())
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	objs := make([]types.Object, 0, len(exportNames))
+	for obj := range exportNames {
+		if !analysis.IsPackageLevel(obj) {
+			objs = append(objs, obj) // field or method
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Pos() < objs[j].Pos() })
+	for _, obj := range objs {
+		if tag := tags[obj]; tag != nil {
+			fmt.Fprintf(os.Stderr, "%s: warning: exporting field %s as %s, which has struct tag %s; "+
+				"its serialization may change\n",
+				o.Fset.Position(obj.Pos()), obj.Name(), exportNames[obj], tag.Value)
+		}
+		for _, pos := range lookups[obj.Name()] {
+			fmt.Fprintf(os.Stderr, "%s: warning: exporting %s as %s, but it is looked up by name here\n",
+				o.Fset.Position(pos), obj.Name(), exportNames[obj])
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// A field of an anonymous struct type, selected from another cluster,
+// is reported as a risk, since exporting it changes the type.
+func TestSafetyAnonymousField(t *testing.T) {
+	o := loadSource(t, map[string]string{"p.go": `package p
+
+var config struct{ verbose bool }
+
+func verbose() bool { return config.verbose }
+
+func quiet() bool { return !verbose() }
+`})
+	partitionWith(t, o, "= p/config\nconfig\n")
+	report := captureStdout(t, o.printSafetyReport)
+	const title = "- fields and methods of anonymous types selected across clusters (1):"
+	if !strings.Contains(report, title) {
+		t.Fatalf("report lacks %q:\n%s", title, report)
+	}
+	if !strings.Contains(report, "p.go:5:37\n") {
+		t.Errorf("report lacks position of config.verbose:\n%s", report)
+	}
+
+	// Within a cluster, the selection is harmless.
+	partitionWith(t, o, "= p/config\nconfig\nverbose\n")
+	if report := captureStdout(t, o.printSafetyReport); strings.Contains(report, title) {
+		t.Errorf("report of a single-cluster selection:\n%s", report)
+	}
+}
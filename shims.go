@@ -0,0 +1,258 @@
+package main
+
+// This file defines shims (-shims): forwarding declarations, in the
+// residue, of the exported symbols moved to other clusters, so that
+// the package's existing importers need not change.
+//
+// Functions are forwarded by a call, constants by a constant of the
+// same value, and types by an alias.  A variable is forwarded by a
+// copy initialized from the original, which is safe only if the
+// package never assigns it, and even then, assignments made by
+// importers to the copy are not seen by the original.  Generic
+// functions and types, variables assigned by the package, and
+// symbols whose declarations mention unexported types of other
+// clusters can't be forwarded; each is reported.
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// A shim is the forwarding declaration of an exported symbol.
+type shim struct {
+	obj     types.Object
+	decl    string        // source text of the declaration
+	imports []interface{} // *types.Package or *cluster
+}
+
+// residueOf returns the residue cluster, or nil if there is none.
+func residueOf(clusters []*analysis.Cluster) *analysis.Cluster {
+	if len(clusters) > 0 && clusters[len(clusters)-1].IsResidue() {
+		return clusters[len(clusters)-1]
+	}
+	return nil
+}
+
+// planShims returns the shims for the exported package-level symbols
+// moved out of the residue, in declaration order, and warns about
+// those that can't be shimmed.  It must be called after
+// computeExports.
+func (o *organizer) planShims(clusters []*analysis.Cluster) []*shim {
+	residue := residueOf(clusters)
+	var shims []*shim
+	for _, n := range o.Nodes {
+		if n.Recv != nil || n.IsTest() || n.Cluster.IsResidue() {
+			continue // methods follow their types; tests aren't API
+		}
+		for _, obj := range n.Objects {
+			if !obj.Exported() {
+				continue
+			}
+			if residue == nil {
+				fmt.Fprintf(os.Stderr, "%s: warning: can't shim %s: there is no residue to hold it\n",
+					o.Fset.Position(obj.Pos()), obj.Name())
+				continue
+			}
+			s, why := o.makeShim(obj, residue)
+			if s == nil {
+				fmt.Fprintf(os.Stderr, "%s: warning: can't shim %s: %s\n",
+					o.Fset.Position(obj.Pos()), obj.Name(), why)
+				continue
+			}
+			shims = append(shims, s)
+		}
+	}
+	return shims
+}
+
+// makeShim returns the shim in the residue for obj, an exported
+// package-level symbol of another cluster, or the reason there can't
+// be one.
+func (o *organizer) makeShim(obj types.Object, residue *analysis.Cluster) (*shim, string) {
+	if prev := o.scopes[residue][obj.Name()]; prev != nil {
+		return nil, fmt.Sprintf("it would conflict with %s in the residue", prev)
+	}
+	s := &shim{obj: obj}
+	c := o.NodesByObj[obj].Cluster
+	s.imports = append(s.imports, c)
+	target := o.clusterNames[c] + "." + obj.Name()
+
+	switch obj := obj.(type) {
+	case *types.Func:
+		sig := obj.Type().(*types.Signature)
+		if sig.TypeParams().Len() > 0 {
+			return nil, "it is generic"
+		}
+		var ptypes []string
+		for i := 0; i < sig.Params().Len(); i++ {
+			typ := sig.Params().At(i).Type()
+			variadic := sig.Variadic() && i == sig.Params().Len()-1
+			if variadic {
+				typ = typ.(*types.Slice).Elem()
+			}
+			text, why := o.shimTypeText(typ, residue, s)
+			if why != "" {
+				return nil, why
+			}
+			if variadic {
+				text = "..." + text
+			}
+			ptypes = append(ptypes, text)
+		}
+		// Parameters must not shadow the imports.
+		imported := make(map[string]bool)
+		for _, imp := range s.imports {
+			switch imp := imp.(type) {
+			case *types.Package:
+				imported[imp.Name()] = true
+			case *analysis.Cluster:
+				imported[o.clusterNames[imp]] = true
+			}
+		}
+		var params, args []string
+		for i, text := range ptypes {
+			name := sig.Params().At(i).Name()
+			if name == "" || name == "_" || imported[name] {
+				name = fmt.Sprintf("p%d", i)
+			}
+			arg := name
+			if sig.Variadic() && i == len(ptypes)-1 {
+				arg += "..."
+			}
+			params = append(params, name+" "+text)
+			args = append(args, arg)
+		}
+		var results []string
+		for i := 0; i < sig.Results().Len(); i++ {
+			text, why := o.shimTypeText(sig.Results().At(i).Type(), residue, s)
+			if why != "" {
+				return nil, why
+			}
+			results = append(results, text)
+		}
+		var result, ret string
+		switch len(results) {
+		case 0:
+		case 1:
+			result, ret = " "+results[0], "return "
+		default:
+			result, ret = " ("+strings.Join(results, ", ")+")", "return "
+		}
+		s.decl = fmt.Sprintf("// %s forwards to %s.\nfunc %s(%s)%s { %s%s(%s) }\n",
+			obj.Name(), target, obj.Name(), strings.Join(params, ", "), result,
+			ret, target, strings.Join(args, ", "))
+
+	case *types.Const:
+		s.decl = fmt.Sprintf("// %s is %s.\nconst %s = %s\n", obj.Name(), target, obj.Name(), target)
+
+	case *types.TypeName:
+		if named, ok := obj.Type().(*types.Named); ok && named.TypeParams().Len() > 0 {
+			return nil, "it is generic"
+		}
+		s.decl = fmt.Sprintf("// %s is %s.\ntype %s = %s\n", obj.Name(), target, obj.Name(), target)
+
+	case *types.Var:
+		if o.isAssigned(obj) {
+			return nil, "the package assigns it, so a copy would diverge"
+		}
+		s.decl = fmt.Sprintf("// %s is a copy of %s; assignments to it do not affect %s.\nvar %s = %s\n",
+			obj.Name(), target, target, obj.Name(), target)
+
+	default:
+		return nil, fmt.Sprintf("unexpected %T", obj)
+	}
+	return s, ""
+}
+
+// qualifiedName matches a qualified name as printed by shimTypeText's
+// qualifier: the package path between NULs, a dot, and the name.
+var qualifiedName = regexp.MustCompile(`\x00([^\x00]*)\x00\.(\w+)`)
+
+// shimTypeText returns the source text of the type T as seen from the
+// residue, recording in s the imports it requires, or the reason it
+// can't be expressed there.
+func (o *organizer) shimTypeText(T types.Type, residue *analysis.Cluster, s *shim) (string, string) {
+	pkgs := make(map[string]*types.Package)
+	text := types.TypeString(T, func(pkg *types.Package) string {
+		pkgs[pkg.Path()] = pkg
+		return "\x00" + pkg.Path() + "\x00"
+	})
+	var why string
+	text = qualifiedName.ReplaceAllStringFunc(text, func(qname string) string {
+		m := qualifiedName.FindStringSubmatch(qname)
+		pkg, name := pkgs[m[1]], m[2]
+		if pkg != o.Info.Pkg {
+			s.imports = append(s.imports, pkg)
+			return pkg.Name() + "." + name
+		}
+		obj := pkg.Scope().Lookup(name)
+		n := o.NodesByObj[obj]
+		if n == nil {
+			why = fmt.Sprintf("its type mentions the local type %s", name)
+			return name
+		}
+		if new, ok := o.exportNames[obj]; ok {
+			name = new
+		}
+		if n.Cluster == residue {
+			return name
+		}
+		if !ast.IsExported(name) {
+			why = fmt.Sprintf("its type mentions %s, which is not exported from %s", name, n.Cluster.ImportPath)
+			return name
+		}
+		s.imports = append(s.imports, n.Cluster)
+		return o.clusterNames[n.Cluster] + "." + name
+	})
+	return text, why
+}
+
+// isAssigned reports whether the package (excluding its tests)
+// assigns v, modifies it in place, or takes its address.
+func (o *organizer) isAssigned(v *types.Var) bool {
+	addressed := make(map[*ast.Ident]bool)
+	for _, n := range o.Nodes {
+		if !n.IsTest() {
+			o.classifyVarUses(n, addressed, make(map[*ast.Ident]bool))
+		}
+	}
+	for id := range addressed {
+		if o.Info.Uses[id] == v {
+			return true
+		}
+	}
+	return false
+}
+
+// writeShims appends the shims to the file shims.go of the residue.
+func (o *organizer) writeShims(clusters []*analysis.Cluster) {
+	shims := o.planShims(clusters)
+	if len(shims) == 0 {
+		return
+	}
+	residue := residueOf(clusters)
+	out := o.file(residue, "shims.go")
+	if out.head.Len() == 0 {
+		fmt.Fprintf(&out.head, "package %s\n\n", analysis.PackageName(residue.ImportPath))
+	}
+	if out.groupDecl != nil {
+		out.body.WriteString(")\n")
+		out.groupDecl = nil
+	}
+	if out.imports == nil {
+		out.imports = make(map[interface{}]bool)
+	}
+	for _, s := range shims {
+		for _, imp := range s.imports {
+			out.imports[imp] = true
+		}
+		fmt.Fprintf(&out.body, "\n%s", s.decl)
+	}
+	fmt.Fprintf(os.Stderr, "note: forwarding %d exported symbols from the residue by shims\n", len(shims))
+}
@@ -0,0 +1,141 @@
+package main
+
+// This file defines -suggest, which proposes clusters for the residue.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// maxSuggestions is the maximum number of clusters proposed by -suggest.
+const maxSuggestions = 10
+
+// A bunch is a set of residue scnodes that may form a new cluster:
+// a stem, and everything below it that only the bunch uses.
+type bunch struct {
+	stem    *analysis.SCNode
+	scnodes map[*analysis.SCNode]bool
+	size    int // number of nodes
+}
+
+// printSuggestions prints, as clusters-file stanzas, the largest
+// maximal bunches of the residue.  The bunch of a residue scnode is
+// the set of residue scnodes reachable from it; it is proposed if
+// every member but the stem has predecessors in the residue only
+// within the bunch, so that the stem is its sole entry, and it
+// contains no pinned node.  Naming the stem in a stanza assigns the
+// whole bunch.
+func (o *organizer) printSuggestions(clusters []*analysis.Cluster) error {
+	residue := residueOf(clusters)
+	if residue == nil {
+		fmt.Println("# The residue is empty: nothing to suggest.")
+		return nil
+	}
+
+	var bunches []*bunch
+	for s := range o.SCGraph(false) {
+		if s.Cluster != residue {
+			continue
+		}
+		if b := residueBunch(s); b != nil && b.size < len(residue.Nodes) {
+			bunches = append(bunches, b)
+		}
+	}
+
+	// Keep the maximal bunches: those whose stems are in no other.
+	// (The maximal bunches are disjoint, since each is entered only
+	// through its stem.)
+	var maximal []*bunch
+	for _, b := range bunches {
+		contained := false
+		for _, b2 := range bunches {
+			if b2 != b && b2.scnodes[b.stem] {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			maximal = append(maximal, b)
+		}
+	}
+	sort.Slice(maximal, func(i, j int) bool {
+		if x, y := maximal[i].size, maximal[j].size; x != y {
+			return x > y
+		}
+		return sccRoot(maximal[i].stem).ID < sccRoot(maximal[j].stem).ID
+	})
+	if len(maximal) > maxSuggestions {
+		maximal = maximal[:maxSuggestions]
+	}
+
+	fmt.Printf("# Suggested clusters for the residue (%d nodes), largest first.\n", len(residue.Nodes))
+	fmt.Println("# Each is named by its stem, the sole entry to the nodes below it.")
+	if len(maximal) == 0 {
+		fmt.Println("# (none)")
+	}
+	for _, b := range maximal {
+		root := sccRoot(b.stem)
+		importPath, err := o.GeneratedPath(suggestedName(root))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\n# %d nodes; residue %d -> %d\n", b.size, len(residue.Nodes), len(residue.Nodes)-b.size)
+		fmt.Printf("= %s\n%s\n", importPath, root.Name)
+	}
+	fmt.Println()
+	return nil
+}
+
+// residueBunch returns the bunch whose stem is s, or nil if it is
+// not self-contained or contains a pinned node.
+func residueBunch(s *analysis.SCNode) *bunch {
+	b := &bunch{stem: s, scnodes: make(map[*analysis.SCNode]bool)}
+	b.scnodes[s] = true
+	for work := []*analysis.SCNode{s}; len(work) > 0; {
+		t := work[len(work)-1]
+		work = work[:len(work)-1] // pop
+		for succ := range t.Succs {
+			if succ.Cluster == s.Cluster && !b.scnodes[succ] {
+				b.scnodes[succ] = true
+				work = append(work, succ)
+			}
+		}
+	}
+	for t := range b.scnodes {
+		for n := range t.Nodes {
+			if n.IsPinned() {
+				return nil
+			}
+		}
+		if t == s {
+			continue
+		}
+		for pred := range t.Preds {
+			if pred.Cluster == s.Cluster && !b.scnodes[pred] {
+				return nil // another entry
+			}
+		}
+		b.size += len(t.Nodes)
+	}
+	b.size += len(s.Nodes)
+	return b
+}
+
+// suggestedName returns a cluster name derived from that of the node:
+// its lower-case letters and digits, e.g. "parseexpr" for parseExpr.
+func suggestedName(n *analysis.Node) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, n.Name)
+	if name == "" {
+		name = "part"
+	}
+	return name
+}
@@ -0,0 +1,20 @@
+package p
+
+var count int
+
+var limit = 10
+
+func bump() {
+	count++
+}
+
+// Over reports whether the count exceeds the limit.
+func Over() bool { return count > limit }
+
+// Reset sets the count and limit.
+func Reset(n int) {
+	limit = n
+	if limit < 0 {
+		limit = -limit
+	}
+}
@@ -0,0 +1,9 @@
+package state
+
+var limit = 10
+
+// Limit returns the value of limit.
+func Limit() int { return limit }
+
+// SetLimit sets the value of limit.
+func SetLimit(v int) { limit = v }
@@ -0,0 +1,22 @@
+package residue
+
+import (
+	"p/state"
+)
+
+var count int
+
+func bump() {
+	count++
+}
+
+// Over reports whether the count exceeds the limit.
+func Over() bool { return count > state.Limit() }
+
+// Reset sets the count and limit.
+func Reset(n int) {
+	state.SetLimit(n)
+	if state.Limit() < 0 {
+		state.SetLimit(-state.Limit())
+	}
+}
@@ -0,0 +1,39 @@
+// Package shapes is a test package for the refactoring.
+package shapes
+
+import (
+	"fmt"
+	"math"
+)
+
+// A shape has an area.
+type shape interface {
+	Area() float64
+}
+
+type (
+	// circle is a shape.
+	circle struct{ r float64 }
+
+	// square is another.
+	square struct{ side float64 }
+)
+
+func (c circle) Area() float64 { return math.Pi * c.r * c.r }
+
+func (s *square) Area() float64 { return s.side * s.side }
+
+var (
+	unit       = circle{1}
+	big, small = square{10}, square{1}
+)
+
+// describe returns a description of the shape.
+func describe(s shape) string {
+	return fmt.Sprintf("%T of area %.2f", s, s.Area())
+}
+
+// Describe describes the standard shapes.
+func Describe() []string {
+	return []string{describe(unit), describe(&big), describe(&small)}
+}
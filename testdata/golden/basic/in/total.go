@@ -0,0 +1,13 @@
+package shapes
+
+// total returns the total area of the shapes.
+func total(shapes ...shape) float64 {
+	var sum float64
+	for _, s := range shapes {
+		sum += s.Area()
+	}
+	return sum
+}
+
+// Total returns the total area of the standard shapes.
+func Total() float64 { return total(unit, &big, &small) }
@@ -0,0 +1,18 @@
+// Package shapes is a test package for the refactoring.
+package residue
+
+import (
+	"fmt"
+	"shapes/geom"
+	"shapes/std"
+)
+
+// describe returns a description of the shape.
+func describe(s geom.Shape) string {
+	return fmt.Sprintf("%T of area %.2f", s, s.Area())
+}
+
+// Describe describes the standard shapes.
+func Describe() []string {
+	return []string{describe(std.Unit), describe(&std.Big), describe(&std.Small)}
+}
@@ -0,0 +1,18 @@
+package residue
+
+import (
+	"shapes/geom"
+	"shapes/std"
+)
+
+// total returns the total area of the shapes.
+func total(shapes ...geom.Shape) float64 {
+	var sum float64
+	for _, s := range shapes {
+		sum += s.Area()
+	}
+	return sum
+}
+
+// Total returns the total area of the standard shapes.
+func Total() float64 { return total(std.Unit, &std.Big, &std.Small) }
@@ -0,0 +1,23 @@
+// Package shapes is a test package for the refactoring.
+package geom
+
+import (
+	"math"
+)
+
+// A shape has an area.
+type Shape interface {
+	Area() float64
+}
+
+type (
+	// circle is a shape.
+	Circle struct{ R float64 }
+
+	// square is another.
+	Square struct{ Side float64 }
+)
+
+func (c Circle) Area() float64 { return math.Pi * c.R * c.R }
+
+func (s *Square) Area() float64 { return s.Side * s.Side }
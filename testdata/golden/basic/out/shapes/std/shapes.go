@@ -0,0 +1,11 @@
+// Package shapes is a test package for the refactoring.
+package std
+
+import (
+	"shapes/geom"
+)
+
+var (
+	Unit       = geom.Circle{R: 1}
+	Big, Small = geom.Square{Side: 10}, geom.Square{Side: 1}
+)
@@ -0,0 +1,5 @@
+package p
+
+import "math/rand"
+
+func roll() int { return rand.Intn(6) + 1 }
@@ -0,0 +1,9 @@
+package p
+
+import "crypto/rand"
+
+func seed() []byte {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return b
+}
@@ -0,0 +1,5 @@
+package p
+
+import . "strings"
+
+func shout(s string) string { return ToUpper(s) + "!" }
@@ -0,0 +1,3 @@
+package p
+
+func heading(s string) string { return "# " + shout(s) }
@@ -0,0 +1,5 @@
+package p
+
+import "fmt"
+
+func show(n int) string { return fmt.Sprint(n) }
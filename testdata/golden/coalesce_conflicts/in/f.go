@@ -0,0 +1,9 @@
+package p
+
+import "fmt"
+
+// Play rolls a die, seeded, and shows a heading.
+func Play() string {
+	fmt.Println(len(seed()))
+	return heading(show(roll()))
+}
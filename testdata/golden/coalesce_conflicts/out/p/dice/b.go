@@ -0,0 +1,11 @@
+package dice
+
+import (
+	"crypto/rand"
+)
+
+func Seed() []byte {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return b
+}
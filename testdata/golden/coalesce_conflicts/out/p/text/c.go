@@ -0,0 +1,7 @@
+package text
+
+import (
+	. "strings"
+)
+
+func shout(s string) string { return ToUpper(s) + "!" }
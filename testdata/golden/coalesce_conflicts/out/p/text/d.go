@@ -0,0 +1,3 @@
+package text
+
+func Heading(s string) string { return "# " + shout(s) }
@@ -0,0 +1,14 @@
+package residue
+
+import (
+	"fmt"
+	"p/dice"
+	_show "p/show"
+	"p/text"
+)
+
+// Play rolls a die, seeded, and shows a heading.
+func Play() string {
+	fmt.Println(len(dice.Seed()))
+	return text.Heading(_show.Show(dice.Roll()))
+}
@@ -0,0 +1,13 @@
+package p
+
+type inner struct{ field int }
+
+type middle struct{ *inner }
+
+type outer struct{ middle }
+
+func newOuter() outer { return outer{middle{&inner{field: 1}}} }
+
+// Field reads a field promoted through two embedded fields,
+// each declared by a cluster of its own.
+func Field() int { return newOuter().field }
@@ -0,0 +1,3 @@
+package low
+
+type Inner struct{ Field int }
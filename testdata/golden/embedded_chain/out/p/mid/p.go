@@ -0,0 +1,7 @@
+package mid
+
+import (
+	"p/low"
+)
+
+type Middle struct{ *low.Inner }
@@ -0,0 +1,14 @@
+package residue
+
+import (
+	"p/low"
+	"p/mid"
+)
+
+type outer struct{ mid.Middle }
+
+func newOuter() outer { return outer{mid.Middle{Inner: &low.Inner{Field: 1}}} }
+
+// Field reads a field promoted through two embedded fields,
+// each declared by a cluster of its own.
+func Field() int { return newOuter().Field }
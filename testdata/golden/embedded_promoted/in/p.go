@@ -0,0 +1,17 @@
+package p
+
+type inner struct{ v int }
+
+// Inner claims the name of inner in their cluster.
+func Inner() int { return 0 }
+
+type outer struct {
+	inner
+	w int
+}
+
+func newOuter() outer { return outer{inner: inner{v: 1}, w: 2} }
+
+// Same selects the embedded field, and thus exports it and its type,
+// though it refers to neither the type nor its fields.
+func Same() bool { return newOuter().inner == newOuter().inner }
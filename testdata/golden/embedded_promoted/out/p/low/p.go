@@ -0,0 +1,13 @@
+package low
+
+type XInner struct{ v int }
+
+// Inner claims the name of inner in their cluster.
+func Inner() int { return 0 }
+
+type Outer struct {
+	XInner
+	w int
+}
+
+func NewOuter() Outer { return Outer{XInner: XInner{v: 1}, w: 2} }
@@ -0,0 +1,9 @@
+package residue
+
+import (
+	"p/low"
+)
+
+// Same selects the embedded field, and thus exports it and its type,
+// though it refers to neither the type nor its fields.
+func Same() bool { return low.NewOuter().XInner == low.NewOuter().XInner }
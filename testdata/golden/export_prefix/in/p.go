@@ -0,0 +1,13 @@
+package p
+
+func foo() int { return 1 }
+
+// Foo is distinct from foo.
+func Foo() int { return 2 }
+
+// XFoo, yes and Zap begin with the usual prefixes.
+func XFoo() int { return foo() + Foo() }
+
+var yes = true
+
+const Zap = 3
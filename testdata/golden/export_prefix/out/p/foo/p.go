@@ -0,0 +1,6 @@
+package foo
+
+func X_Foo() int { return 1 }
+
+// Foo is distinct from foo.
+func Foo() int { return 2 }
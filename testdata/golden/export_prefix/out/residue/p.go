@@ -0,0 +1,12 @@
+package residue
+
+import (
+	_foo "p/foo"
+)
+
+// XFoo, yes and Zap begin with the usual prefixes.
+func XFoo() int { return _foo.X_Foo() + _foo.Foo() }
+
+var yes = true
+
+const Zap = 3
@@ -0,0 +1,14 @@
+package p
+
+type point struct {
+	x int
+	X int
+}
+
+func newPoint() point { return point{x: 1, X: 2} }
+
+// Sum returns the sum of both fields of a point.
+func Sum() int {
+	p := newPoint()
+	return p.x + p.X
+}
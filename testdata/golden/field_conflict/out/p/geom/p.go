@@ -0,0 +1,8 @@
+package geom
+
+type Point struct {
+	YX int
+	X  int
+}
+
+func NewPoint() Point { return Point{YX: 1, X: 2} }
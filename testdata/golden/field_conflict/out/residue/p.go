@@ -0,0 +1,11 @@
+package residue
+
+import (
+	"p/geom"
+)
+
+// Sum returns the sum of both fields of a point.
+func Sum() int {
+	p := geom.NewPoint()
+	return p.YX + p.X
+}
@@ -0,0 +1,25 @@
+package p
+
+type List[T any] struct{ x []T }
+
+func (l *List[T]) Push(v T) { l.x = append(l.x, v) }
+
+func (l *List[T]) len() int { return len(l.x) }
+
+type pair[K comparable, V any] struct {
+	k K
+	v V
+}
+
+// Sum returns the sum of the elements of a list of ints.
+func Sum() int {
+	var l List[int]
+	l.Push(1)
+	l.Push(2)
+	sum := l.len()
+	for _, v := range l.x {
+		sum += v
+	}
+	p := pair[string, int]{"a", sum}
+	return p.v
+}
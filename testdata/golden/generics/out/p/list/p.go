@@ -0,0 +1,12 @@
+package list
+
+type List[T any] struct{ X []T }
+
+func (l *List[T]) Push(v T) { l.X = append(l.X, v) }
+
+func (l *List[T]) Len() int { return len(l.X) }
+
+type Pair[K comparable, V any] struct {
+	K K
+	V V
+}
@@ -0,0 +1,18 @@
+package residue
+
+import (
+	"p/list"
+)
+
+// Sum returns the sum of the elements of a list of ints.
+func Sum() int {
+	var l list.List[int]
+	l.Push(1)
+	l.Push(2)
+	sum := l.Len()
+	for _, v := range l.X {
+		sum += v
+	}
+	p := list.Pair[string, int]{K: "a", V: sum}
+	return p.V
+}
@@ -0,0 +1,5 @@
+package p
+
+import Out "fmt"
+
+func show(n int) string { return Out.Sprint(n) }
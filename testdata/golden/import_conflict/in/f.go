@@ -0,0 +1,4 @@
+package p
+
+// Play shows a number.
+func Play() string { return out(show(6)) }
@@ -0,0 +1,4 @@
+package p
+
+// out becomes Out once exported, the name of an import of e.go.
+func out(s string) string { return "> " + s }
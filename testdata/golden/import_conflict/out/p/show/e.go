@@ -0,0 +1,7 @@
+package show
+
+import (
+	Out "fmt"
+)
+
+func Show(n int) string { return Out.Sprint(n) }
@@ -0,0 +1,4 @@
+package show
+
+// out becomes Out once exported, the name of an import of e.go.
+func XOut(s string) string { return "> " + s }
@@ -0,0 +1,8 @@
+package residue
+
+import (
+	_show "p/show"
+)
+
+// Play shows a number.
+func Play() string { return _show.XOut(_show.Show(6)) }
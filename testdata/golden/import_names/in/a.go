@@ -0,0 +1,10 @@
+package p
+
+import (
+	. "strings"
+	str "strconv"
+)
+
+func upper(s string) string { return ToUpper(s) }
+
+func quote(s string) string { return str.Quote(s) }
@@ -0,0 +1,6 @@
+package p
+
+import fmtpkg "fmt"
+
+// Show prints a word.
+func Show(s string) { fmtpkg.Println(quote(upper(s))) }
@@ -0,0 +1,10 @@
+package text
+
+import (
+	str "strconv"
+	. "strings"
+)
+
+func Upper(s string) string { return ToUpper(s) }
+
+func Quote(s string) string { return str.Quote(s) }
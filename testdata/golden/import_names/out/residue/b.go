@@ -0,0 +1,9 @@
+package residue
+
+import (
+	fmtpkg "fmt"
+	"p/text"
+)
+
+// Show prints a word.
+func Show(s string) { fmtpkg.Println(text.Quote(text.Upper(s))) }
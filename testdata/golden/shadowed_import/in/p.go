@@ -0,0 +1,12 @@
+package p
+
+func area(w, h int) int { return w * h }
+
+// Total returns the area of a square and a rectangle.
+func Total(side int) int {
+	geom := area(side, side)
+	return geom + area(side, 2)
+}
+
+// Plain refers to area where no local shadows the cluster name.
+func Plain() int { return area(1, 1) }
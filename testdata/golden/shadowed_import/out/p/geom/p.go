@@ -0,0 +1,3 @@
+package geom
+
+func Area(w, h int) int { return w * h }
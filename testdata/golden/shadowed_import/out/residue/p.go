@@ -0,0 +1,14 @@
+package residue
+
+import (
+	_geom "p/geom"
+)
+
+// Total returns the area of a square and a rectangle.
+func Total(side int) int {
+	geom := _geom.Area(side, side)
+	return geom + _geom.Area(side, 2)
+}
+
+// Plain refers to area where no local shadows the cluster name.
+func Plain() int { return _geom.Area(1, 1) }
@@ -0,0 +1,11 @@
+package p
+
+import (
+	"os"
+	"strings"
+)
+
+func env(key string) string { return os.Getenv(key) }
+
+// Home returns the home directory, trimmed.
+func Home() string { return strings.TrimSpace(env("HOME")) }
@@ -0,0 +1,7 @@
+package env
+
+import (
+	"os"
+)
+
+func Env(key string) string { return os.Getenv(key) }
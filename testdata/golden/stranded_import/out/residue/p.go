@@ -0,0 +1,9 @@
+package residue
+
+import (
+	_env "p/env"
+	"strings"
+)
+
+// Home returns the home directory, trimmed.
+func Home() string { return strings.TrimSpace(_env.Env("HOME")) }
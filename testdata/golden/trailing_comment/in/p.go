@@ -0,0 +1,10 @@
+package p
+
+func low() int { return 1 }
+
+// High returns a high number.
+func High() int { return low() + 1 }
+
+/*
+TODO: return higher numbers.
+*/
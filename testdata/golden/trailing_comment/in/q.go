@@ -0,0 +1,5 @@
+package p
+
+func lower() int { return low() - 1 }
+
+// The end.
@@ -0,0 +1,3 @@
+package low
+
+func Low() int { return 1 }
@@ -0,0 +1,12 @@
+package residue
+
+import (
+	_low "p/low"
+)
+
+// High returns a high number.
+func High() int { return _low.Low() + 1 }
+
+/*
+TODO: return higher numbers.
+*/
@@ -0,0 +1,9 @@
+package residue
+
+import (
+	_low "p/low"
+)
+
+func lower() int { return _low.Low() - 1 }
+
+// The end.
@@ -0,0 +1,211 @@
+package main
+
+// This file defines -verify, which checks that the refactored output
+// compiles.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// verifyOutput builds the output packages of the clusters, split from
+// the package in srcDir, with the go command, and reports the
+// compilation errors of each cluster.  If srcDir lies within a module,
+// the build is in module mode (see verifyModule); otherwise the output
+// directory serves as the src directory of a temporary GOPATH
+// workspace, ahead of the user's GOPATH, so that the clusters import
+// each other by their import paths, and the build is in GOPATH mode.
+func verifyOutput(srcDir string, clusters []*analysis.Cluster) error {
+	outAbs, err := filepath.Abs(*outdir)
+	if err != nil {
+		return err
+	}
+	workspace, err := ioutil.TempDir("", "sockdrawer")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workspace)
+
+	gomod, _, err := goCommand(srcDir, nil, "env", "GOMOD")
+	if err != nil {
+		return err
+	}
+	if gomod = strings.TrimSpace(gomod); gomod != "" && gomod != os.DevNull {
+		return verifyModule(gomod, srcDir, outAbs, workspace, clusters)
+	}
+
+	src := filepath.Join(workspace, "src")
+	if err := os.Symlink(outAbs, src); err != nil {
+		return err
+	}
+	gopath := workspace
+	if old := os.Getenv("GOPATH"); old != "" {
+		gopath += string(filepath.ListSeparator) + old
+	}
+	return buildOutput(src, []string{"GOPATH=" + gopath, "GO111MODULE=off"}, nil, clusters,
+		func(file string) string {
+			return strings.Replace(file, src+string(filepath.Separator), outAbs+string(filepath.Separator), 1)
+		})
+}
+
+// verifyModule builds the output packages in module mode, as part of
+// the module whose go.mod file is gomod, so that they resolve the
+// module's other packages and its dependencies as the original
+// package does.  An overlay (go build -overlay) replaces the source
+// files of the original package, in srcDir, by the output, and adds
+// the output packages whose import paths lie within the module to
+// its tree.  Each other output package forms a module of its own in
+// the workspace directory, and the go.work file of the workspace
+// joins them all.
+func verifyModule(gomod, srcDir, outAbs, workspace string, clusters []*analysis.Cluster) error {
+	data, _, err := goCommand(srcDir, nil, "mod", "edit", "-json", gomod)
+	if err != nil {
+		return err
+	}
+	var mod struct {
+		Module struct{ Path string }
+		Go     string
+	}
+	if err := json.Unmarshal([]byte(data), &mod); err != nil {
+		return fmt.Errorf("can't read %s: %v", gomod, err)
+	}
+	root := filepath.Dir(gomod)
+	goVersion := mod.Go
+	var minor int
+	fmt.Sscanf(goVersion, "1.%d", &minor)
+	if minor < 18 {
+		goVersion = "1.18" // the first with workspaces
+	}
+
+	// Hide the original package.
+	replace := make(map[string]string) // overlay: file -> output file, or "" to hide it
+	files, err := filepath.Glob(filepath.Join(srcDir, "*.go"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		replace[file] = ""
+	}
+
+	var uses []string // modules of the go.work file
+	for _, c := range clusters {
+		var dir string
+		if c.ImportPath == mod.Module.Path || strings.HasPrefix(c.ImportPath, mod.Module.Path+"/") {
+			rel := strings.TrimPrefix(c.ImportPath, mod.Module.Path)
+			dir = filepath.Join(root, filepath.FromSlash(rel))
+		} else {
+			// outside the module
+			dir = filepath.Join(workspace, filepath.FromSlash(c.ImportPath))
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			content := fmt.Sprintf("module %s\n\ngo %s\n", c.ImportPath, goVersion)
+			if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(content), 0666); err != nil {
+				return err
+			}
+			uses = append(uses, dir)
+		}
+		entries, err := ioutil.ReadDir(filepath.Join(outAbs, filepath.FromSlash(c.ImportPath)))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !e.IsDir() && e.Name() != manifestName {
+				replace[filepath.Join(dir, e.Name())] = filepath.Join(outAbs, filepath.FromSlash(c.ImportPath), e.Name())
+			}
+		}
+	}
+
+	overlay := filepath.Join(workspace, "overlay.json")
+	data2, err := json.Marshal(struct{ Replace map[string]string }{replace})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(overlay, data2, 0666); err != nil {
+		return err
+	}
+	env := []string{"GO111MODULE=on"}
+	if uses != nil {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "go %s\n\nuse (\n\t%s\n", goVersion, root)
+		for _, dir := range uses {
+			fmt.Fprintf(&buf, "\t%s\n", dir)
+		}
+		buf.WriteString(")\n")
+		gowork := filepath.Join(workspace, "go.work")
+		if err := ioutil.WriteFile(gowork, buf.Bytes(), 0666); err != nil {
+			return err
+		}
+		env = append(env, "GOWORK="+gowork)
+	}
+	return buildOutput(root, env, []string{"-overlay", overlay}, clusters,
+		func(file string) string { return file })
+}
+
+// buildOutput runs "go build" on the output packages of the clusters in
+// dir, with the additional environment and flags, and reports the
+// errors of each cluster.  Since the go command names the files with
+// errors by paths relative to dir, or absolute ones, outFile maps the
+// absolute path of each to that of the output file it denotes.
+func buildOutput(dir string, env, flags []string, clusters []*analysis.Cluster, outFile func(string) string) error {
+	outAbs, err := filepath.Abs(*outdir)
+	if err != nil {
+		return err
+	}
+	args := append([]string{"build"}, flags...)
+	for _, c := range clusters {
+		args = append(args, c.ImportPath)
+	}
+	fmt.Fprintf(os.Stderr, "Verifying that the output compiles...\n")
+	_, out, err := goCommand(dir, env, args...)
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "\tok\n")
+		return nil
+	} else if _, ok := err.(*exec.ExitError); !ok {
+		return fmt.Errorf("can't run go build: %v", err)
+	}
+
+	// The go command prints a "# importpath" line before the errors
+	// of each package; name the cluster there, and show the files
+	// by their paths within the output directory.
+	for _, line := range strings.Split(strings.TrimRight(out, "\n"), "\n") {
+		if strings.HasPrefix(line, "# ") {
+			line = "# cluster " + line[2:]
+		} else if i := strings.Index(line, ".go:"); i > 0 {
+			file := line[:i+len(".go")]
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(dir, file)
+			}
+			if rel, err := filepath.Rel(outAbs, outFile(file)); err == nil && !strings.HasPrefix(rel, "..") {
+				line = filepath.Join(*outdir, rel) + line[i+len(".go"):]
+			}
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
+	return fmt.Errorf("the output does not compile")
+}
+
+// goCommand runs the go command in dir, with the additional
+// environment, and returns its standard output and error.  The
+// user's GOFLAGS do not apply.
+func goCommand(dir string, env []string, args ...string) (stdout, stderr string, err error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GOFLAGS="), env...)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	err = cmd.Run()
+	if err != nil && args[0] != "build" {
+		err = fmt.Errorf("go %s: %v\n%s", strings.Join(args, " "), err, &errBuf)
+	}
+	return outBuf.String(), errBuf.String(), err
+}
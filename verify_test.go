@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arl/sockdrawer/internal/analysistest"
+)
+
+// Within a module, -verify builds the output in module mode, so that
+// the clusters resolve the module's other packages, and a cluster
+// outside the module becomes a module of its own.
+func TestVerifyModule(t *testing.T) {
+	root := analysistest.WriteFiles(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.18\n",
+		"q/q.go": "package q\n\nconst N = 1\n",
+		"p/p.go": `package p
+
+import "example.com/m/q"
+
+func low() int { return q.N }
+
+func other() int { return low() + 1 }
+
+func F() int { return other() }
+`,
+	})
+	// Resolve the imports by the module's go.mod, as from within it.
+	t.Setenv("GOFLAGS", "")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	setFlag(t, "residue-path", "example.com/m/p")
+	o := loadDir(t, filepath.Join(root, "p"))
+	setFlag(t, "outdir", t.TempDir())
+	setFlag(t, "verify", "true")
+	clusters := partitionWith(t, o, "= example.com/m/p/low\nlow\n\n= other.org/x\nother\n")
+	stderr := captureStderr(t, func() { err = o.refactor(clusters) })
+	if err != nil {
+		t.Fatalf("refactor: %v\n%s", err, stderr)
+	}
+	if !strings.Contains(stderr, "Verifying that the output compiles...\n\tok\n") {
+		t.Errorf("stderr = %q, want the output to compile", stderr)
+	}
+}
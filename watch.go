@@ -0,0 +1,74 @@
+package main
+
+// This file defines -watch, which keeps the type-checked package and
+// its node graph resident, like -repl, but reloads the clusters file
+// whenever it changes on disk.
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arl/sockdrawer/analysis"
+)
+
+// watchInterval is how often -watch checks the clusters file.
+const watchInterval = 500 * time.Millisecond
+
+// watch polls the clusters file, and the files it includes, and each
+// time one of them changes, reloads it and emits the output selected
+// by the flags.  Only the partition and the output are recomputed.
+// Errors in the clusters file are reported, not fatal; watch returns
+// only if it can't read the file at all.
+func (o *organizer) watch() error {
+	fmt.Fprintf(os.Stderr, "Watching %s for changes (interrupt to quit)...\n", *clusterFile)
+	stamp := watchStamp(*clusterFile)
+	for {
+		time.Sleep(watchInterval)
+		new := watchStamp(*clusterFile)
+		if new == stamp {
+			continue
+		}
+		stamp = new
+		if _, err := os.Stat(*clusterFile); err != nil {
+			if os.IsNotExist(err) {
+				continue // e.g. an editor replacing the file
+			}
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "\n%s: %s changed; reloading\n",
+			time.Now().Format("15:04:05"), *clusterFile)
+		if _, err := o.reload(); err != nil {
+			fmt.Fprintf(os.Stderr, "sockdrawer: %s\n", err)
+		}
+	}
+}
+
+// watchStamp returns a summary of the modification times and sizes
+// of the clusters file and the files it includes, which changes
+// whenever any of them does.
+func watchStamp(filename string) string {
+	files := []string{filename}
+	if lines, err := analysis.ReadClusterLines(filename); err == nil {
+		seen := map[string]bool{filename: true}
+		for _, l := range lines {
+			if !seen[l.Filename] {
+				seen[l.Filename] = true
+				files = append(files, l.Filename)
+			}
+		}
+	}
+	sort.Strings(files[1:])
+
+	var stamps []string
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			stamps = append(stamps, fmt.Sprintf("%s %d %d", file, info.ModTime().UnixNano(), info.Size()))
+		} else {
+			stamps = append(stamps, file+" missing")
+		}
+	}
+	return strings.Join(stamps, "\n")
+}